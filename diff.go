@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContextLines is the number of unchanged lines shown around each change
+const diffContextLines = 3
+
+// diffOp is one line of an edit script: ' ' kept, '-' removed, '+' added
+type diffOp struct {
+	Kind byte
+	Line string
+}
+
+// unifiedDiff returns a unified diff between the old and new contents of path,
+// or an empty string if they are equal. Files are small (resolv.conf, .desktop
+// files), so a plain LCS table is good enough.
+func unifiedDiff(path string, oldData, newData []byte) string {
+	oldLines := splitDiffLines(string(oldData))
+	newLines := splitDiffLines(string(newData))
+	ops := diffLines(oldLines, newLines)
+
+	changed := false
+	for _, op := range ops {
+		if op.Kind != ' ' {
+			changed = true
+			break
+		}
+	}
+	if !changed {
+		return ""
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", path, path)
+
+	// Walk the script and emit hunks of changes with their surrounding context
+	oldLine, newLine := 1, 1
+	for i := 0; i < len(ops); {
+		if ops[i].Kind == ' ' {
+			oldLine++
+			newLine++
+			i++
+			continue
+		}
+
+		start := i - diffContextLines
+		if start < 0 {
+			start = 0
+		}
+		// Extend the hunk while changes are closer than two context blocks apart
+		end := i
+		for end < len(ops) {
+			if ops[end].Kind != ' ' {
+				end++
+				continue
+			}
+			run := end
+			for run < len(ops) && ops[run].Kind == ' ' {
+				run++
+			}
+			if run == len(ops) || run-end > 2*diffContextLines {
+				end += min(diffContextLines, run-end)
+				break
+			}
+			end = run
+		}
+
+		hunkOldStart, hunkNewStart := oldLine-(i-start), newLine-(i-start)
+		oldCount, newCount := 0, 0
+		var body strings.Builder
+		for _, op := range ops[start:end] {
+			switch op.Kind {
+			case ' ':
+				oldCount++
+				newCount++
+			case '-':
+				oldCount++
+			case '+':
+				newCount++
+			}
+			body.WriteByte(op.Kind)
+			body.WriteString(op.Line)
+			body.WriteByte('\n')
+		}
+		fmt.Fprintf(&sb, "@@ -%s +%s @@\n", hunkRange(hunkOldStart, oldCount), hunkRange(hunkNewStart, newCount))
+		sb.WriteString(body.String())
+
+		for _, op := range ops[i:end] {
+			if op.Kind != '+' {
+				oldLine++
+			}
+			if op.Kind != '-' {
+				newLine++
+			}
+		}
+		i = end
+	}
+	return sb.String()
+}
+
+// hunkRange formats the start,count pair of a hunk header
+func hunkRange(start, count int) string {
+	if count == 0 {
+		// An empty range refers to the line before it
+		return fmt.Sprintf("%d,0", start-1)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+func splitDiffLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// diffLines computes an edit script turning a into b from their longest common subsequence
+func diffLines(a, b []string) []diffOp {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var ops []diffOp
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		ops = append(ops, diffOp{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		ops = append(ops, diffOp{'+', b[j]})
+	}
+	return ops
+}
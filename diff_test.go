@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// numberedLines returns "1\n2\n...n\n" with the lines in replace swapped out
+func numberedLines(n int, replace map[int]string) string {
+	var sb strings.Builder
+	for i := 1; i <= n; i++ {
+		if line, ok := replace[i]; ok {
+			sb.WriteString(line)
+		} else {
+			fmt.Fprintf(&sb, "%d", i)
+		}
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	const header = "--- /etc/resolv.conf\n+++ /etc/resolv.conf\n"
+	tests := []struct {
+		name     string
+		old, new string
+		want     string
+	}{
+		{
+			name: "identical",
+			old:  "nameserver 1.1.1.1\nnameserver 9.9.9.9\n",
+			new:  "nameserver 1.1.1.1\nnameserver 9.9.9.9\n",
+			want: "",
+		},
+		{
+			name: "both empty",
+			want: "",
+		},
+		{
+			name: "new file",
+			new:  "nameserver 1.1.1.1\nnameserver 9.9.9.9\n",
+			want: header + "@@ -0,0 +1,2 @@\n+nameserver 1.1.1.1\n+nameserver 9.9.9.9\n",
+		},
+		{
+			name: "pure insert",
+			old:  "search lan\nnameserver 9.9.9.9\n",
+			new:  "search lan\nnameserver 1.1.1.1\nnameserver 9.9.9.9\n",
+			want: header + "@@ -1,2 +1,3 @@\n search lan\n+nameserver 1.1.1.1\n nameserver 9.9.9.9\n",
+		},
+		{
+			name: "pure delete",
+			old:  "search lan\nnameserver 1.1.1.1\nnameserver 9.9.9.9\n",
+			new:  "search lan\nnameserver 9.9.9.9\n",
+			want: header + "@@ -1,3 +1,2 @@\n search lan\n-nameserver 1.1.1.1\n nameserver 9.9.9.9\n",
+		},
+		{
+			name: "removed file",
+			old:  "nameserver 1.1.1.1\n",
+			want: header + "@@ -1 +0,0 @@\n-nameserver 1.1.1.1\n",
+		},
+		{
+			name: "middle change with context",
+			old:  numberedLines(10, nil),
+			new:  numberedLines(10, map[int]string{5: "five"}),
+			want: header + "@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n",
+		},
+		{
+			name: "changes far apart",
+			old:  numberedLines(20, nil),
+			new:  numberedLines(20, map[int]string{2: "two", 18: "eighteen"}),
+			want: header +
+				"@@ -1,5 +1,5 @@\n 1\n-2\n+two\n 3\n 4\n 5\n" +
+				"@@ -15,6 +15,6 @@\n 15\n 16\n 17\n-18\n+eighteen\n 19\n 20\n",
+		},
+		{
+			name: "changes close together",
+			old:  numberedLines(12, nil),
+			new:  numberedLines(12, map[int]string{3: "three", 8: "eight"}),
+			want: header + "@@ -1,11 +1,11 @@\n 1\n 2\n-3\n+three\n 4\n 5\n 6\n 7\n-8\n+eight\n 9\n 10\n 11\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := unifiedDiff("/etc/resolv.conf", []byte(tt.old), []byte(tt.new))
+			if got != tt.want {
+				t.Errorf("unifiedDiff =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// dnsBackend is one of the mechanisms a Linux system can use to manage its
+// resolvers. applyDNS and restoreDNS delegate to whichever backend owns DNS
+// on the machine.
+type dnsBackend interface {
+	// Name returns a short human readable identifier for logs and the GUI
+	Name() string
+	// Apply installs servers as the system resolvers
+	Apply(servers []string) error
+	// Restore reverts whatever Apply changed
+	Restore() error
+	// Current returns the nameservers the system is using through this mechanism, in order
+	Current() ([]string, error)
+	// CurrentConfig returns the effective resolvers of every interface, including
+	// ones we never touched (e.g. servers handed out by DHCP)
+	CurrentConfig() ([]interfaceDNS, error)
+}
+
+// nameserverLimiter is implemented by backends that can only apply a limited number of nameservers
+type nameserverLimiter interface {
+	MaxNameservers() int
+}
+
+// dotApplier is implemented by backends that can apply DNS-over-TLS servers.
+// DNS-over-TLS is switched on for a link as a whole, so its servers can't be
+// mixed with plain ones.
+type dotApplier interface {
+	SupportsDNSOverTLS() bool
+}
+
+// canApplyDNSOverTLS reports whether the system DNS can be set to DNS-over-TLS servers
+func canApplyDNSOverTLS() bool {
+	if runtime.GOOS != "linux" {
+		return false
+	}
+	applier, ok := getLinuxBackend().(dotApplier)
+	return ok && applier.SupportsDNSOverTLS()
+}
+
+var (
+	linuxBackendMu   sync.Mutex
+	linuxBackendImpl dnsBackend
+)
+
+// selectLinuxBackend detects which program manages DNS and picks the matching
+// backend, unless linux_backend in the config names one explicitly
+func selectLinuxBackend() dnsBackend {
+	detection := detectLinuxDNSManager()
+	appState.SetDNSManager(detection.Summary())
+	appState.AddLog(fmt.Sprintf("Detected DNS manager: %s", detection.Summary()))
+
+	id := detection.Manager
+	if config.LinuxBackend != "" && config.LinuxBackend != backendAuto {
+		id = config.LinuxBackend
+		appState.AddLog(fmt.Sprintf("Using %s backend as configured by linux_backend", id))
+	}
+	backend, err := newLinuxBackend(id)
+	if err != nil {
+		appState.AddLog(fmt.Sprintf("Warning: %v, falling back to detected %s", err, detection.Manager))
+		if backend, err = newLinuxBackend(detection.Manager); err != nil {
+			appState.AddLog(fmt.Sprintf("Warning: %v, falling back to resolv.conf", err))
+			backend = &resolvConfFileBackend{}
+		}
+	}
+
+	linuxBackendMu.Lock()
+	linuxBackendImpl = backend
+	linuxBackendMu.Unlock()
+
+	appState.AddLog(fmt.Sprintf("Using %s backend for DNS changes", backend.Name()))
+	return backend
+}
+
+// getLinuxBackend returns the backend used for applying DNS on Linux, so that
+// restoreDNS talks to the same backend that applyDNS used
+func getLinuxBackend() dnsBackend {
+	linuxBackendMu.Lock()
+	backend := linuxBackendImpl
+	linuxBackendMu.Unlock()
+	if backend == nil {
+		backend = selectLinuxBackend()
+	}
+	return backend
+}
+
+// resolvConfFileBackend edits /etc/resolv.conf directly. It is only used
+// when nothing else manages DNS on the system.
+type resolvConfFileBackend struct{}
+
+func (b *resolvConfFileBackend) Name() string {
+	return "resolv.conf"
+}
+
+func (b *resolvConfFileBackend) Apply(servers []string) error {
+	addrs, err := parseServerList(servers)
+	if err != nil {
+		return err
+	}
+	if err := requireStandardPort(addrs, "resolv.conf"); err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(resolvConfPath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read %s: %v", resolvConfPath, err)
+	}
+
+	perm := os.FileMode(0644)
+	uid, gid := -1, -1
+	// Stat follows a symlink; the link itself is replaced by a regular file
+	if info, err := os.Stat(resolvConfPath); err == nil {
+		perm = info.Mode().Perm()
+		if u, g, ok := fileOwner(info); ok {
+			uid, gid = u, g
+		}
+	}
+
+	// Only the nameserver lines change, search domains, options and comments are kept
+	conf := parseResolvConf(data)
+	conf.SetNameservers(servers)
+	if err := writeSystemFile(resolvConfPath, conf.Bytes(), perm, uid, gid); err != nil {
+		return fmt.Errorf("failed to write %s: %v", resolvConfPath, err)
+	}
+
+	if appState.GetDebugMode() {
+		appState.AddLog(fmt.Sprintf("Set nameservers in %s to %s", resolvConfPath, strings.Join(servers, ", ")))
+		if selected := appState.GetSelectedInterface(); selected != "" {
+			appState.AddLog(fmt.Sprintf("Note: %s is system wide, the selected interface %s is not used", resolvConfPath, selected))
+		}
+	}
+	return nil
+}
+
+func (b *resolvConfFileBackend) MaxNameservers() int {
+	return resolvConfMaxNameservers
+}
+
+func (b *resolvConfFileBackend) Restore() error {
+	// The original file is put back from the snapshot taken before the first change
+	return nil
+}
+
+func (b *resolvConfFileBackend) Current() ([]string, error) {
+	return readResolvConfNameservers()
+}
+
+func (b *resolvConfFileBackend) CurrentConfig() ([]interfaceDNS, error) {
+	return readResolvConfConfig()
+}
+
+// readResolvConfNameservers returns the nameservers listed in /etc/resolv.conf
+func readResolvConfNameservers() ([]string, error) {
+	data, err := os.ReadFile(resolvConfPath)
+	if err != nil {
+		return nil, err
+	}
+	return parseResolvConf(data).Nameservers(), nil
+}
+
+// readResolvConfConfig reports the nameservers of /etc/resolv.conf, which apply to all interfaces
+func readResolvConfConfig() ([]interfaceDNS, error) {
+	servers, err := readResolvConfNameservers()
+	if err != nil {
+		return nil, err
+	}
+	return []interfaceDNS{{Servers: servers, Source: resolvConfPath}}, nil
+}
+
+// getActiveLinuxInterfaces lists the interfaces that are up and can carry DNS
+// traffic, with the default route interface first
+func getActiveLinuxInterfaces() ([]string, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	defaultIface, _ := defaultRouteInterface()
+
+	var names []string
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil || len(addrs) == 0 {
+			continue
+		}
+		if iface.Name == defaultIface {
+			names = append([]string{iface.Name}, names...)
+		} else {
+			names = append(names, iface.Name)
+		}
+	}
+	return names, nil
+}
+
+// linuxTargetInterface returns the interface DNS should be applied to: the one
+// selected in the Status tab if it is still up, otherwise the default route interface
+func linuxTargetInterface() (string, error) {
+	selected := appState.GetSelectedInterface()
+	if selected != "" {
+		if iface, err := net.InterfaceByName(selected); err == nil && iface.Flags&net.FlagUp != 0 {
+			return selected, nil
+		}
+		appState.AddLog(fmt.Sprintf("Selected interface %s not found, using the default route interface", selected))
+	}
+	name, err := defaultRouteInterface()
+	if err != nil {
+		return "", fmt.Errorf("failed to find default route interface: %v", err)
+	}
+	return name, nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+// interfaceDNS lists the resolvers the system uses on one interface
+type interfaceDNS struct {
+	Interface string   // empty when the configuration applies to all interfaces
+	Servers   []string // in the order they are tried
+	Source    string   // where the information was read from, e.g. "systemd-resolved"
+}
+
+// String returns a one line description such as "eth0: 192.168.1.1, fd00::1"
+func (d interfaceDNS) String() string {
+	name := d.Interface
+	if name == "" {
+		name = "all interfaces"
+	}
+	servers := "none"
+	if len(d.Servers) > 0 {
+		servers = strings.Join(d.Servers, ", ")
+	}
+	return fmt.Sprintf("%s: %s", name, servers)
+}
+
+// readSystemDNS reads the resolvers the system is actually using, whether or not
+// we applied them
+func readSystemDNS() ([]interfaceDNS, error) {
+	switch runtime.GOOS {
+	case "windows":
+		return readWindowsDNS()
+	case "linux":
+		return getLinuxBackend().CurrentConfig()
+	case "darwin":
+		return readDarwinDNS()
+	default:
+		return nil, fmt.Errorf("unsupported operating system: %s", runtime.GOOS)
+	}
+}
+
+// refreshSystemDNS re-reads the system resolvers into the app state
+func refreshSystemDNS() {
+	configs, err := readSystemDNS()
+	if err != nil {
+		appState.AddLog(fmt.Sprintf("Warning: Failed to read current DNS configuration: %v", err))
+		return
+	}
+	appState.SetSystemDNS(configs)
+	if appState.GetDebugMode() {
+		for _, c := range configs {
+			appState.AddLog(fmt.Sprintf("System DNS (%s) %s", c.Source, c))
+		}
+	}
+}
+
+// systemResolvers returns the distinct resolver addresses of a configuration, in order
+func systemResolvers(configs []interfaceDNS) []string {
+	var servers []string
+	seen := make(map[string]bool)
+	for _, c := range configs {
+		for _, server := range c.Servers {
+			if !seen[server] {
+				seen[server] = true
+				servers = append(servers, server)
+			}
+		}
+	}
+	return servers
+}
+
+// readWindowsDNS lists the DNS servers of every interface that has some
+func readWindowsDNS() ([]interfaceDNS, error) {
+	cmd := exec.Command("powershell", "-NoProfile", "-NonInteractive",
+		"Get-DnsClientServerAddress | Where-Object { $_.ServerAddresses } | ForEach-Object { $_.InterfaceAlias + '|' + ($_.ServerAddresses -join ',') }")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("error executing PowerShell command: %v, output: %s", err, output)
+	}
+
+	// There is one line per interface and address family, merge them
+	var configs []interfaceDNS
+	position := make(map[string]int)
+	for _, line := range strings.Split(strings.ReplaceAll(string(output), "\r\n", "\n"), "\n") {
+		alias, servers, ok := strings.Cut(strings.TrimSpace(line), "|")
+		if !ok || alias == "" {
+			continue
+		}
+		i, seen := position[alias]
+		if !seen {
+			i = len(configs)
+			position[alias] = i
+			configs = append(configs, interfaceDNS{Interface: alias, Source: "Get-DnsClientServerAddress"})
+		}
+		for _, server := range strings.Split(servers, ",") {
+			if server = strings.TrimSpace(server); server != "" {
+				configs[i].Servers = append(configs[i].Servers, server)
+			}
+		}
+	}
+	return configs, nil
+}
+
+var (
+	scutilNameserver = regexp.MustCompile(`^nameserver\[\d+\]\s*:\s*(\S+)`)
+	scutilInterface  = regexp.MustCompile(`^if_index\s*:\s*\d+\s*\((\S+)\)`)
+)
+
+// readDarwinDNS reads the default resolvers from scutil, falling back to
+// /etc/resolv.conf, which macOS generates from the same configuration
+func readDarwinDNS() ([]interfaceDNS, error) {
+	output, err := exec.Command("scutil", "--dns").Output()
+	if err != nil {
+		data, readErr := os.ReadFile(resolvConfPath)
+		if readErr != nil {
+			return nil, fmt.Errorf("failed to run scutil: %v", err)
+		}
+		return []interfaceDNS{{Servers: parseResolvConf(data).Nameservers(), Source: resolvConfPath}}, nil
+	}
+
+	// Only the first section holds the unscoped resolvers; resolvers with a
+	// domain are used for that domain only (e.g. local or VPN split DNS)
+	var configs []interfaceDNS
+	var current *interfaceDNS
+	hasDomain := false
+	flush := func() {
+		if current != nil && !hasDomain && len(current.Servers) > 0 {
+			configs = append(configs, *current)
+		}
+		current = nil
+		hasDomain = false
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "DNS configuration (for scoped queries)"):
+			flush()
+			return configs, nil
+		case strings.HasPrefix(line, "resolver #"):
+			flush()
+			current = &interfaceDNS{Source: "scutil"}
+		case current == nil:
+		case strings.HasPrefix(line, "domain"):
+			hasDomain = true
+		default:
+			if m := scutilNameserver.FindStringSubmatch(line); m != nil {
+				current.Servers = append(current.Servers, m[1])
+			} else if m := scutilInterface.FindStringSubmatch(line); m != nil {
+				current.Interface = m[1]
+			}
+		}
+	}
+	flush()
+	return configs, nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Identifiers accepted by the linux_backend config option
+const (
+	backendAuto           = "auto"
+	backendNetworkManager = "networkmanager"
+	backendResolved       = "systemd-resolved"
+	backendResolvconf     = "resolvconf"
+	backendFile           = "file"
+)
+
+// dnsManagerDetection describes which program manages DNS on this machine and why we think so
+type dnsManagerDetection struct {
+	Manager  string   // one of the backend identifiers above
+	Evidence []string // observations that led to the decision, for the Status tab and logs
+}
+
+// Summary returns a one line description for the GUI
+func (d dnsManagerDetection) Summary() string {
+	if len(d.Evidence) == 0 {
+		return d.Manager
+	}
+	return fmt.Sprintf("%s (%s)", d.Manager, strings.Join(d.Evidence, "; "))
+}
+
+// detectLinuxDNSManager inspects /etc/resolv.conf, running services and installed
+// tools to find out what owns DNS on this system
+func detectLinuxDNSManager() dnsManagerDetection {
+	var evidence []string
+
+	linkTarget := ""
+	if target, err := os.Readlink(resolvConfPath); err == nil {
+		linkTarget = target
+		evidence = append(evidence, fmt.Sprintf("%s -> %s", resolvConfPath, target))
+	}
+	header := resolvConfHeader(resolvConfPath)
+
+	nmRunning := networkManagerAvailable()
+	if nmRunning {
+		evidence = append(evidence, "NetworkManager is running")
+	}
+	resolvedRunning := resolvedAvailable()
+	if resolvedRunning {
+		evidence = append(evidence, "systemd-resolved is on the system bus")
+	}
+	_, resolvconfErr := exec.LookPath("resolvconf")
+	hasResolvconf := resolvconfErr == nil
+	if hasResolvconf {
+		evidence = append(evidence, "resolvconf is installed")
+	}
+
+	stubLink := strings.Contains(linkTarget, "systemd/resolve")
+	resolvconfLink := strings.Contains(linkTarget, "resolvconf")
+	nmHeader := strings.Contains(header, "NetworkManager")
+	resolvconfHeader := strings.Contains(header, "resolvconf")
+	if nmHeader {
+		evidence = append(evidence, "resolv.conf generated by NetworkManager")
+	} else if resolvconfHeader {
+		evidence = append(evidence, "resolv.conf generated by resolvconf")
+	}
+
+	detection := dnsManagerDetection{Evidence: evidence}
+	switch {
+	case nmRunning:
+		// NetworkManager also feeds systemd-resolved and resolvconf when they are in use,
+		// so settings have to go through it or they are lost on the next DHCP renewal
+		detection.Manager = backendNetworkManager
+	case resolvedRunning:
+		detection.Manager = backendResolved
+	case hasResolvconf && (resolvconfLink || resolvconfHeader):
+		detection.Manager = backendResolvconf
+	default:
+		if stubLink {
+			detection.Evidence = append(detection.Evidence, "warning: resolv.conf points at the systemd-resolved stub but the service is not running")
+		}
+		detection.Manager = backendFile
+	}
+	return detection
+}
+
+// resolvConfHeader returns the leading comment block of a resolv.conf file
+func resolvConfHeader(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	var header []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "#") && !strings.HasPrefix(line, ";") {
+			break
+		}
+		header = append(header, line)
+	}
+	return strings.Join(header, "\n")
+}
+
+// newLinuxBackend creates the backend for an identifier
+func newLinuxBackend(id string) (dnsBackend, error) {
+	switch id {
+	case backendNetworkManager:
+		return &networkManagerBackend{}, nil
+	case backendResolved:
+		return &resolvedBackend{}, nil
+	case backendResolvconf:
+		return &resolvconfBackend{}, nil
+	case backendFile:
+		return &resolvConfFileBackend{}, nil
+	default:
+		return nil, fmt.Errorf("unknown linux_backend %q (expected %s, %s, %s, %s or %s)",
+			id, backendAuto, backendNetworkManager, backendResolved, backendResolvconf, backendFile)
+	}
+}
+
+// describeSystemDNSManager returns a description of the DNS mechanism used on
+// non-Linux systems, where there is only one
+func describeSystemDNSManager(goos string) string {
+	switch goos {
+	case "windows":
+		return "Windows DNS Client (Set-DnsClientServerAddress)"
+	case "darwin":
+		return "macOS (networksetup)"
+	default:
+		return goos
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/gen2brain/beeep"
+	"gopkg.in/yaml.v2"
+)
+
+// Values accepted by the drift_mode config option
+const (
+	driftModeLog     = "log"     // only record the event
+	driftModeNotify  = "notify"  // record and show a notification
+	driftModeReapply = "reapply" // record and put our servers back immediately
+)
+
+const (
+	driftLogFile = "dns_drift.log"
+	// driftDebounce groups the bursts of events a single rewrite produces
+	driftDebounce = time.Second
+	// Re-applying more often than this means we are fighting another program,
+	// at which point we stop and only notify
+	driftMaxReapplies   = 5
+	driftReapplyWindow  = 10 * time.Minute
+	driftForeignMaxSize = 64 * 1024
+)
+
+// driftWatchDirs are the directories where the various DNS managers write resolv.conf.
+// Directories are watched rather than files because most writers replace the file.
+var driftWatchDirs = []string{
+	"/etc",
+	"/run/systemd/resolve",
+	"/run/NetworkManager",
+	"/run/resolvconf",
+}
+
+// driftEvent records something else replacing the DNS configuration we applied
+type driftEvent struct {
+	Time           time.Time `yaml:"time"`
+	Backend        string    `yaml:"backend"`
+	Trigger        string    `yaml:"trigger"`
+	Expected       []string  `yaml:"expected"`
+	Found          []string  `yaml:"found"`
+	ForeignContent string    `yaml:"foreign_content,omitempty"` // resolv.conf at the time, which usually names the writer in its header
+	Action         string    `yaml:"action"`
+}
+
+// driftWatcher watches the resolver configuration while our DNS settings are applied
+type driftWatcher struct {
+	watcher *fsnotify.Watcher
+	done    chan struct{}
+
+	mu        sync.Mutex // serialises checks
+	timer     *time.Timer
+	reapplies []time.Time
+}
+
+var (
+	driftMu     sync.Mutex
+	activeDrift *driftWatcher
+)
+
+// startDriftWatcher begins watching for foreign changes to the DNS configuration.
+// It is a no-op if a watcher is already running.
+func startDriftWatcher() {
+	if runtime.GOOS != "linux" {
+		return
+	}
+	driftMu.Lock()
+	defer driftMu.Unlock()
+	if activeDrift != nil {
+		return
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		appState.AddLog(fmt.Sprintf("Warning: Failed to start DNS drift detection: %v", err))
+		return
+	}
+	watching := 0
+	for _, dir := range driftWatchDirs {
+		if _, err := os.Stat(dir); err != nil {
+			continue
+		}
+		if err := watcher.Add(dir); err != nil {
+			appState.AddLog(fmt.Sprintf("Warning: Failed to watch %s for DNS drift: %v", dir, err))
+			continue
+		}
+		watching++
+	}
+	if watching == 0 {
+		watcher.Close()
+		appState.AddLog("Warning: DNS drift detection has nothing to watch")
+		return
+	}
+
+	activeDrift = &driftWatcher{watcher: watcher, done: make(chan struct{})}
+	go activeDrift.loop()
+	if appState.GetDebugMode() {
+		appState.AddLog(fmt.Sprintf("DNS drift detection started (mode: %s)", driftMode()))
+	}
+}
+
+// stopDriftWatcher stops watching, e.g. because our settings were restored
+func stopDriftWatcher() {
+	driftMu.Lock()
+	defer driftMu.Unlock()
+	if activeDrift == nil {
+		return
+	}
+	close(activeDrift.done)
+	activeDrift.watcher.Close()
+	activeDrift.mu.Lock()
+	if activeDrift.timer != nil {
+		activeDrift.timer.Stop()
+	}
+	activeDrift.mu.Unlock()
+	activeDrift = nil
+}
+
+func (d *driftWatcher) loop() {
+	for {
+		select {
+		case event, ok := <-d.watcher.Events:
+			if !ok {
+				return
+			}
+			base := filepath.Base(event.Name)
+			if base != "resolv.conf" && base != "stub-resolv.conf" {
+				continue
+			}
+			trigger := fmt.Sprintf("%s %s", event.Op, event.Name)
+			d.mu.Lock()
+			if d.timer != nil {
+				d.timer.Stop()
+			}
+			d.timer = time.AfterFunc(driftDebounce, func() { d.check(trigger) })
+			d.mu.Unlock()
+		case err, ok := <-d.watcher.Errors:
+			if !ok {
+				return
+			}
+			appState.AddLog(fmt.Sprintf("Warning: DNS drift watcher error: %v", err))
+		case <-d.done:
+			return
+		}
+	}
+}
+
+// check compares the live configuration with what we applied and reacts to a difference
+func (d *driftWatcher) check(trigger string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	select {
+	case <-d.done:
+		return
+	default:
+	}
+
+	expected, _ := appState.GetCurrentDNS()
+	if len(expected) == 0 {
+		return
+	}
+	backend := getLinuxBackend()
+	current, err := backend.Current()
+	if err != nil {
+		appState.AddLog(fmt.Sprintf("Warning: Failed to read DNS configuration for drift check: %v", err))
+		return
+	}
+	// Other contributors may add servers next to ours (e.g. resolvconf merging a VPN record),
+	// and backends may reorder them (NetworkManager lists IPv4 before IPv6), so only
+	// missing servers count as drift
+	if hasAllServers(current, expected) {
+		return
+	}
+
+	event := driftEvent{
+		Time:     time.Now(),
+		Backend:  backend.Name(),
+		Trigger:  trigger,
+		Expected: expected,
+		Found:    current,
+		Action:   driftMode(),
+	}
+	if data, err := os.ReadFile(resolvConfPath); err == nil {
+		if len(data) > driftForeignMaxSize {
+			data = data[:driftForeignMaxSize]
+		}
+		event.ForeignContent = string(data)
+	}
+
+	message := fmt.Sprintf("DNS drift detected: expected %s but found %s (%s)",
+		strings.Join(expected, ", "), strings.Join(current, ", "), trigger)
+
+	switch event.Action {
+	case driftModeReapply:
+		if !d.allowReapply() {
+			event.Action = driftModeNotify
+			message += fmt.Sprintf(" - re-applied %d times in %v, giving up", driftMaxReapplies, driftReapplyWindow)
+			notifyDrift(message)
+			break
+		}
+		if err := backend.Apply(expected); err != nil {
+			message += fmt.Sprintf(" - failed to re-apply: %v", err)
+		} else {
+			message += " - re-applied"
+		}
+	case driftModeNotify:
+		notifyDrift(message)
+	}
+
+	appState.AddLog(message)
+	if err := recordDriftEvent(event); err != nil {
+		appState.AddLog(fmt.Sprintf("Warning: Failed to record drift event: %v", err))
+	}
+	updateLogsDisplay()
+}
+
+// allowReapply rate limits re-applying so we don't fight another program forever
+func (d *driftWatcher) allowReapply() bool {
+	now := time.Now()
+	var recent []time.Time
+	for _, t := range d.reapplies {
+		if now.Sub(t) < driftReapplyWindow {
+			recent = append(recent, t)
+		}
+	}
+	d.reapplies = recent
+	if len(recent) >= driftMaxReapplies {
+		return false
+	}
+	d.reapplies = append(d.reapplies, now)
+	return true
+}
+
+// driftMode returns the configured drift_mode, defaulting to log
+func driftMode() string {
+	switch config.DriftMode {
+	case driftModeNotify, driftModeReapply:
+		return config.DriftMode
+	default:
+		return driftModeLog
+	}
+}
+
+func notifyDrift(message string) {
+	if err := beeep.Notify("DNS Changed Externally", message, ""); err != nil {
+		appState.AddLog(fmt.Sprintf("Warning: Failed to show notification: %v", err))
+	}
+}
+
+// recordDriftEvent appends an event to the drift log next to the config, one YAML document per event
+func recordDriftEvent(event driftEvent) error {
+	path, err := getDataPath(driftLogFile)
+	if err != nil {
+		return err
+	}
+	data, err := yaml.Marshal(&event)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(append([]byte("---\n"), data...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// hasAllServers reports whether every expected server is in current, in any order
+func hasAllServers(current, expected []string) bool {
+	for _, server := range expected {
+		if !containsString(current, server) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestHasAllServers(t *testing.T) {
+	tests := []struct {
+		name     string
+		current  []string
+		expected []string
+		want     bool
+	}{
+		{"same order", []string{"1.1.1.1", "9.9.9.9"}, []string{"1.1.1.1", "9.9.9.9"}, true},
+		// NetworkManager lists IPv4 servers before IPv6 ones, which is not drift
+		{"reordered", []string{"1.1.1.1", "2606:4700:4700::1111"}, []string{"2606:4700:4700::1111", "1.1.1.1"}, true},
+		{"extra servers", []string{"10.8.0.1", "1.1.1.1", "9.9.9.9"}, []string{"1.1.1.1", "9.9.9.9"}, true},
+		{"nothing expected", []string{"1.1.1.1"}, nil, true},
+		{"one missing", []string{"1.1.1.1"}, []string{"1.1.1.1", "9.9.9.9"}, false},
+		{"replaced", []string{"192.168.1.1"}, []string{"1.1.1.1"}, false},
+		{"none left", nil, []string{"1.1.1.1"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hasAllServers(tt.current, tt.expected); got != tt.want {
+				t.Errorf("hasAllServers(%v, %v) = %v, want %v", tt.current, tt.expected, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// dnsJournalFile marks that our DNS settings are in effect. It is written after every
+// successful change and removed by restoreDNS, so finding it at startup means the
+// previous run ended without restoring (crash, kill or power loss), unless that run
+// marked it as left applied on purpose (restore_on_exit off).
+const dnsJournalFile = "dns_journal.yaml"
+
+// dnsJournal records what was applied and what is needed to undo it in a later process
+type dnsJournal struct {
+	AppliedAt time.Time `yaml:"applied_at"`
+	PID       int       `yaml:"pid"`
+	OS        string    `yaml:"os"`
+	Backend   string    `yaml:"backend"`
+	Interface string    `yaml:"interface,omitempty"` // selected interface at the time
+	Servers   []string  `yaml:"servers"`
+
+	// LeftApplied is set when the run exited normally and kept its settings on purpose
+	LeftApplied bool `yaml:"left_applied,omitempty"`
+
+	// Backend state that only lives in memory otherwise
+	ResolvedIfindex    int              `yaml:"resolved_ifindex,omitempty"`
+	ResolvconfRecord   string           `yaml:"resolvconf_record,omitempty"`
+	NMConnectionUUID   string           `yaml:"nm_connection_uuid,omitempty"`
+	NMDevice           string           `yaml:"nm_device,omitempty"`
+	NMOriginalSettings *nmConnectionDNS `yaml:"nm_original_settings,omitempty"`
+}
+
+// journaledBackend is implemented by backends that need state from Apply to restore,
+// so it can be saved in the journal and handed to the backend of the next process
+type journaledBackend interface {
+	SaveJournal(j *dnsJournal)
+	LoadJournal(j *dnsJournal)
+}
+
+// writeDNSJournal records that servers are applied
+func writeDNSJournal(servers []string) error {
+	if appState.GetDryRun() {
+		return nil
+	}
+	path, err := getDataPath(dnsJournalFile)
+	if err != nil {
+		return err
+	}
+
+	journal := dnsJournal{
+		AppliedAt: time.Now(),
+		PID:       os.Getpid(),
+		OS:        runtime.GOOS,
+		Backend:   appState.GetDNSManager(),
+		Interface: appState.GetSelectedInterface(),
+		Servers:   servers,
+	}
+	if runtime.GOOS == "linux" {
+		backend := getLinuxBackend()
+		journal.Backend = backend.Name()
+		if jb, ok := backend.(journaledBackend); ok {
+			jb.SaveJournal(&journal)
+		}
+	}
+
+	data, err := yaml.Marshal(&journal)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0600, -1, -1)
+}
+
+// loadDNSJournal returns the journal left by a previous run, or nil if it exited cleanly
+func loadDNSJournal() (*dnsJournal, error) {
+	path, err := getDataPath(dnsJournalFile)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var journal dnsJournal
+	if err := yaml.Unmarshal(data, &journal); err != nil {
+		return nil, fmt.Errorf("failed to parse DNS journal: %v", err)
+	}
+	return &journal, nil
+}
+
+// markDNSJournalLeftApplied records that the applied settings are kept on purpose
+// at exit, so the next run takes them over instead of treating them as left by a crash
+func markDNSJournalLeftApplied() error {
+	if appState.GetDryRun() {
+		return nil
+	}
+	journal, err := loadDNSJournal()
+	if err != nil || journal == nil {
+		return err
+	}
+	journal.LeftApplied = true
+
+	path, err := getDataPath(dnsJournalFile)
+	if err != nil {
+		return err
+	}
+	data, err := yaml.Marshal(journal)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0600, -1, -1)
+}
+
+// clearDNSJournal records that our settings are no longer in effect
+func clearDNSJournal() error {
+	if appState.GetDryRun() {
+		return nil
+	}
+	path, err := getDataPath(dnsJournalFile)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// adoptDNSJournal takes over the settings of a previous run, so that restoreDNS
+// (on Stop or Quit) can undo them later
+func adoptDNSJournal(journal *dnsJournal) {
+	if journal.Interface != "" && containsString(appState.GetInterfaces(), journal.Interface) {
+		appState.SetSelectedInterface(journal.Interface)
+	}
+	if runtime.GOOS == "linux" {
+		backend := getLinuxBackend()
+		if backend.Name() != journal.Backend {
+			appState.AddLog(fmt.Sprintf("Warning: DNS was changed through %s but %s is in use now, restoring may be incomplete",
+				journal.Backend, backend.Name()))
+		} else if jb, ok := backend.(journaledBackend); ok {
+			jb.LoadJournal(journal)
+		}
+	}
+	appState.SetCurrentDNS(journal.Servers, -1)
+}
+
+// recoverDNS restores the original DNS settings left behind by a previous run
+func recoverDNS(journal *dnsJournal) error {
+	adoptDNSJournal(journal)
+	appState.AddLog(fmt.Sprintf("Restoring DNS settings left behind by the previous run (%s applied at %s)",
+		strings.Join(journal.Servers, ", "), journal.AppliedAt.Format(time.RFC1123)))
+	return restoreDNS()
+}
+
+// isHeadless reports whether there is no graphical session to ask the user in
+func isHeadless() bool {
+	switch runtime.GOOS {
+	case "windows", "darwin":
+		return false
+	default:
+		return os.Getenv("DISPLAY") == "" && os.Getenv("WAYLAND_DISPLAY") == ""
+	}
+}
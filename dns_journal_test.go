@@ -0,0 +1,159 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"runtime"
+	"slices"
+	"testing"
+)
+
+// useTestJournal points the journal functions at a clean state: dry run off, the given
+// backend in use and no journal on disk, and puts everything back afterwards
+func useTestJournal(t *testing.T, backend dnsBackend) {
+	t.Helper()
+	if runtime.GOOS != "linux" {
+		t.Skip("backend state is only journaled on Linux")
+	}
+	path, err := getDataPath(dnsJournalFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); err == nil {
+		t.Fatalf("%s already exists", path)
+	}
+
+	linuxBackendMu.Lock()
+	previous := linuxBackendImpl
+	linuxBackendImpl = backend
+	linuxBackendMu.Unlock()
+	dryRun := appState.GetDryRun()
+	appState.SetDryRun(false)
+	interfaces, selected := appState.GetInterfaces(), appState.GetSelectedInterface()
+	servers, index := appState.GetCurrentDNS()
+
+	t.Cleanup(func() {
+		os.Remove(path)
+		linuxBackendMu.Lock()
+		linuxBackendImpl = previous
+		linuxBackendMu.Unlock()
+		appState.SetDryRun(dryRun)
+		appState.SetInterfaces(interfaces)
+		appState.SetSelectedInterface(selected)
+		appState.SetCurrentDNS(servers, index)
+	})
+}
+
+// setLinuxBackend swaps the backend in use, e.g. to stand in for the next process
+func setLinuxBackend(backend dnsBackend) {
+	linuxBackendMu.Lock()
+	linuxBackendImpl = backend
+	linuxBackendMu.Unlock()
+}
+
+func TestDNSJournalRoundTrip(t *testing.T) {
+	original := &nmConnectionDNS{IPv4DNS: "", IPv4IgnoreAutoDNS: "no", IPv6DNS: "", IPv6IgnoreAutoDNS: "no"}
+	applied := &networkManagerBackend{
+		uuid:     "0b7e3a52-3c1f-4a8e-9d55-2f0c6a1e9b41",
+		device:   "wlan0",
+		original: original,
+	}
+	useTestJournal(t, applied)
+	appState.SetInterfaces([]string{"eth0", "wlan0"})
+	appState.SetSelectedInterface("wlan0")
+	servers := []string{"1.1.1.1", "2606:4700:4700::1111"}
+
+	if err := writeDNSJournal(servers); err != nil {
+		t.Fatalf("writeDNSJournal: %v", err)
+	}
+	journal, err := loadDNSJournal()
+	if err != nil || journal == nil {
+		t.Fatalf("loadDNSJournal = %v, %v", journal, err)
+	}
+	if journal.PID != os.Getpid() || journal.OS != runtime.GOOS || journal.Backend != "NetworkManager" ||
+		journal.Interface != "wlan0" || !slices.Equal(journal.Servers, servers) || journal.LeftApplied {
+		t.Errorf("journal = %+v", journal)
+	}
+	if journal.NMConnectionUUID != applied.uuid || journal.NMDevice != "wlan0" || !reflect.DeepEqual(journal.NMOriginalSettings, original) {
+		t.Errorf("journaled NetworkManager state = %q %q %+v", journal.NMConnectionUUID, journal.NMDevice, journal.NMOriginalSettings)
+	}
+
+	if err := markDNSJournalLeftApplied(); err != nil {
+		t.Fatalf("markDNSJournalLeftApplied: %v", err)
+	}
+	left, err := loadDNSJournal()
+	if err != nil || left == nil {
+		t.Fatalf("loadDNSJournal = %v, %v", left, err)
+	}
+	if !left.LeftApplied {
+		t.Error("journal is not marked as left applied")
+	}
+	left.LeftApplied = false
+	if !reflect.DeepEqual(left, journal) {
+		t.Errorf("marking changed the journal:\n%+v\nwas\n%+v", left, journal)
+	}
+
+	// The next process starts with a fresh backend and takes the settings over
+	next := &networkManagerBackend{}
+	setLinuxBackend(next)
+	appState.SetSelectedInterface("eth0")
+	appState.SetCurrentDNS(nil, -1)
+	adoptDNSJournal(journal)
+	if next.uuid != applied.uuid || next.device != "wlan0" || !reflect.DeepEqual(next.original, original) {
+		t.Errorf("adopted NetworkManager state = %q %q %+v", next.uuid, next.device, next.original)
+	}
+	if selected := appState.GetSelectedInterface(); selected != "wlan0" {
+		t.Errorf("selected interface = %q, want wlan0", selected)
+	}
+	if current, _ := appState.GetCurrentDNS(); !slices.Equal(current, servers) {
+		t.Errorf("current DNS = %v, want %v", current, servers)
+	}
+
+	if err := clearDNSJournal(); err != nil {
+		t.Fatalf("clearDNSJournal: %v", err)
+	}
+	if journal, err := loadDNSJournal(); journal != nil || err != nil {
+		t.Errorf("loadDNSJournal after clearing = %+v, %v", journal, err)
+	}
+	if err := markDNSJournalLeftApplied(); err != nil {
+		t.Errorf("markDNSJournalLeftApplied without a journal: %v", err)
+	}
+	if journal, err := loadDNSJournal(); journal != nil || err != nil {
+		t.Errorf("marking created a journal: %+v, %v", journal, err)
+	}
+}
+
+func TestAdoptDNSJournalOtherBackend(t *testing.T) {
+	useTestJournal(t, &resolvconfBackend{})
+	appState.SetInterfaces([]string{"eth0"})
+	journal := &dnsJournal{
+		Backend:          "NetworkManager",
+		Interface:        "wlan0",
+		Servers:          []string{"9.9.9.9"},
+		NMConnectionUUID: "0b7e3a52-3c1f-4a8e-9d55-2f0c6a1e9b41",
+		ResolvconfRecord: "eth0.alternatedns",
+	}
+	adoptDNSJournal(journal)
+
+	backend := getLinuxBackend().(*resolvconfBackend)
+	if backend.record != "" {
+		t.Errorf("state of another backend was loaded: record %q", backend.record)
+	}
+	if selected := appState.GetSelectedInterface(); selected == "wlan0" {
+		t.Error("an interface that is gone was selected")
+	}
+	if current, _ := appState.GetCurrentDNS(); !slices.Equal(current, journal.Servers) {
+		t.Errorf("current DNS = %v, want %v", current, journal.Servers)
+	}
+}
+
+func TestDNSJournalDryRun(t *testing.T) {
+	useTestJournal(t, &resolvConfFileBackend{})
+	appState.SetDryRun(true)
+	if err := writeDNSJournal([]string{"1.1.1.1"}); err != nil {
+		t.Fatalf("writeDNSJournal: %v", err)
+	}
+	if journal, err := loadDNSJournal(); journal != nil || err != nil {
+		t.Errorf("dry run wrote a journal: %+v, %v", journal, err)
+	}
+}
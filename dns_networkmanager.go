@@ -0,0 +1,286 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// nmConnectionDNS holds the DNS related settings of a NetworkManager connection profile
+type nmConnectionDNS struct {
+	IPv4DNS           string `yaml:"ipv4_dns"`
+	IPv4IgnoreAutoDNS string `yaml:"ipv4_ignore_auto_dns"`
+	IPv6DNS           string `yaml:"ipv6_dns"`
+	IPv6IgnoreAutoDNS string `yaml:"ipv6_ignore_auto_dns"`
+}
+
+// networkManagerBackend sets DNS on the active NetworkManager connection profile.
+// Unlike writing resolv.conf this survives DHCP renewals, since NetworkManager
+// itself is told to ignore the DNS servers handed out by the network.
+type networkManagerBackend struct {
+	mu       sync.Mutex
+	uuid     string           // connection we changed, empty if nothing has been applied yet
+	device   string           // device the connection is active on
+	original *nmConnectionDNS // settings before our first change
+}
+
+// networkManagerAvailable reports whether NetworkManager is running and nmcli is installed
+func networkManagerAvailable() bool {
+	if _, err := exec.LookPath("nmcli"); err != nil {
+		return false
+	}
+	output, err := exec.Command("nmcli", "-t", "-f", "RUNNING", "general").Output()
+	return err == nil && strings.TrimSpace(string(output)) == "running"
+}
+
+func (b *networkManagerBackend) Name() string {
+	return "NetworkManager"
+}
+
+func (b *networkManagerBackend) Apply(servers []string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	addrs, err := parseServerList(servers)
+	if err != nil {
+		return err
+	}
+	if err := requireStandardPort(addrs, "NetworkManager"); err != nil {
+		return err
+	}
+
+	device, uuid, err := nmActiveConnection()
+	if err != nil {
+		return err
+	}
+
+	// Only record the original settings once, so repeated rotations don't
+	// overwrite them with our own servers
+	if b.original == nil || b.uuid != uuid {
+		if b.original != nil {
+			if err := b.restoreLocked(); err != nil {
+				appState.AddLog(fmt.Sprintf("Warning: Failed to restore previous connection %s: %v", b.uuid, err))
+			}
+		}
+		original, err := nmGetConnectionDNS(uuid)
+		if err != nil {
+			return err
+		}
+		b.original = original
+		b.uuid = uuid
+		b.device = device
+	}
+
+	var v4, v6 []string
+	for _, addr := range addrs {
+		if addr.IP.To4() != nil {
+			v4 = append(v4, addr.IP.String())
+		} else {
+			v6 = append(v6, addr.IP.String())
+		}
+	}
+
+	settings := &nmConnectionDNS{
+		IPv4DNS:           strings.Join(v4, ","),
+		IPv4IgnoreAutoDNS: "yes",
+		IPv6DNS:           strings.Join(v6, ","),
+		IPv6IgnoreAutoDNS: "yes",
+	}
+	if err := nmSetConnectionDNS(uuid, settings); err != nil {
+		return err
+	}
+	if err := nmReapply(device); err != nil {
+		return err
+	}
+
+	if appState.GetDebugMode() {
+		appState.AddLog(fmt.Sprintf("Set DNS on connection %s (%s) to %s via NetworkManager", uuid, device, strings.Join(servers, ", ")))
+	}
+	return nil
+}
+
+func (b *networkManagerBackend) Restore() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.original == nil {
+		appState.AddLog("No NetworkManager DNS changes to restore")
+		return nil
+	}
+	return b.restoreLocked()
+}
+
+// Current returns the DNS servers NetworkManager has configured on the device at runtime
+func (b *networkManagerBackend) Current() ([]string, error) {
+	b.mu.Lock()
+	device := b.device
+	b.mu.Unlock()
+	if device == "" {
+		var err error
+		device, _, err = nmActiveConnection()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return nmDeviceDNS(device)
+}
+
+// CurrentConfig returns the DNS servers NetworkManager has configured on each active device
+func (b *networkManagerBackend) CurrentConfig() ([]interfaceDNS, error) {
+	ifaces, err := getActiveLinuxInterfaces()
+	if err != nil {
+		return nil, err
+	}
+	var configs []interfaceDNS
+	for _, iface := range ifaces {
+		servers, err := nmDeviceDNS(iface)
+		if err != nil {
+			// Devices NetworkManager doesn't manage can't be shown
+			continue
+		}
+		configs = append(configs, interfaceDNS{Interface: iface, Servers: servers, Source: "NetworkManager"})
+	}
+	return configs, nil
+}
+
+// nmDeviceDNS returns the runtime DNS servers of a device
+func nmDeviceDNS(device string) ([]string, error) {
+	output, err := exec.Command("nmcli", "-g", "IP4.DNS,IP6.DNS", "device", "show", device).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read DNS of device %s: %v. Output: %s", device, err, string(output))
+	}
+	var servers []string
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		// Multiple values of one field are separated by " | "
+		for _, value := range strings.Split(line, "|") {
+			value = strings.TrimSpace(nmUnescape(value))
+			if value != "" {
+				servers = append(servers, value)
+			}
+		}
+	}
+	return servers, nil
+}
+
+func (b *networkManagerBackend) SaveJournal(j *dnsJournal) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	j.NMConnectionUUID = b.uuid
+	j.NMDevice = b.device
+	j.NMOriginalSettings = b.original
+}
+
+func (b *networkManagerBackend) LoadJournal(j *dnsJournal) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.uuid = j.NMConnectionUUID
+	b.device = j.NMDevice
+	b.original = j.NMOriginalSettings
+}
+
+// restoreLocked puts back the original connection settings. Callers must hold b.mu.
+func (b *networkManagerBackend) restoreLocked() error {
+	if err := nmSetConnectionDNS(b.uuid, b.original); err != nil {
+		return err
+	}
+	if err := nmReapply(b.device); err != nil {
+		return err
+	}
+	appState.AddLog(fmt.Sprintf("Restored original DNS settings on connection %s via NetworkManager", b.uuid))
+	b.original = nil
+	b.uuid = ""
+	b.device = ""
+	return nil
+}
+
+// nmActiveConnection returns the device DNS should be applied to and the UUID of the connection active on it
+func nmActiveConnection() (string, string, error) {
+	target, err := linuxTargetInterface()
+	if err != nil {
+		return "", "", err
+	}
+
+	output, err := exec.Command("nmcli", "-t", "-f", "DEVICE,UUID", "connection", "show", "--active").CombinedOutput()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to list active connections: %v. Output: %s", err, string(output))
+	}
+
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		fields := nmSplitTerse(line)
+		if len(fields) == 2 && fields[0] == target {
+			return fields[0], fields[1], nil
+		}
+	}
+	return "", "", fmt.Errorf("no active NetworkManager connection on %s", target)
+}
+
+// nmGetConnectionDNS reads the DNS settings of a connection profile
+func nmGetConnectionDNS(uuid string) (*nmConnectionDNS, error) {
+	cmd := exec.Command("nmcli", "-g", "ipv4.dns,ipv4.ignore-auto-dns,ipv6.dns,ipv6.ignore-auto-dns", "connection", "show", uuid)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read settings of connection %s: %v. Output: %s", uuid, err, string(output))
+	}
+	lines := strings.Split(strings.TrimRight(string(output), "\n"), "\n")
+	if len(lines) != 4 {
+		return nil, fmt.Errorf("unexpected output reading connection %s: %q", uuid, string(output))
+	}
+	return &nmConnectionDNS{
+		IPv4DNS:           nmUnescape(lines[0]),
+		IPv4IgnoreAutoDNS: nmUnescape(lines[1]),
+		IPv6DNS:           nmUnescape(lines[2]),
+		IPv6IgnoreAutoDNS: nmUnescape(lines[3]),
+	}, nil
+}
+
+// nmSetConnectionDNS writes the DNS settings of a connection profile
+func nmSetConnectionDNS(uuid string, settings *nmConnectionDNS) error {
+	output, err := runSystemCommand("", "nmcli", "connection", "modify", uuid,
+		"ipv4.dns", settings.IPv4DNS,
+		"ipv4.ignore-auto-dns", settings.IPv4IgnoreAutoDNS,
+		"ipv6.dns", settings.IPv6DNS,
+		"ipv6.ignore-auto-dns", settings.IPv6IgnoreAutoDNS)
+	if err != nil {
+		return fmt.Errorf("failed to modify connection %s: %v. Output: %s", uuid, err, string(output))
+	}
+	return nil
+}
+
+// nmReapply makes NetworkManager apply changed connection settings to a device
+// without bringing the connection down
+func nmReapply(device string) error {
+	output, err := runSystemCommand("", "nmcli", "device", "reapply", device)
+	if err != nil {
+		return fmt.Errorf("failed to reapply settings on %s: %v. Output: %s", device, err, string(output))
+	}
+	return nil
+}
+
+// nmSplitTerse splits a line of nmcli terse output on unescaped colons
+func nmSplitTerse(line string) []string {
+	var fields []string
+	var current strings.Builder
+	escaped := false
+	for _, r := range line {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == ':':
+			fields = append(fields, current.String())
+			current.Reset()
+		default:
+			current.WriteRune(r)
+		}
+	}
+	return append(fields, current.String())
+}
+
+// nmUnescape removes the backslash escaping nmcli applies to values in terse mode
+func nmUnescape(value string) string {
+	return strings.Join(nmSplitTerse(value), ":")
+}
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// probeUDPPayloadSize is the EDNS0 buffer size we advertise (DNS Flag Day 2020)
+	probeUDPPayloadSize = 1232
+	// defaultQueryType is the record type probes ask for unless test_query_type says otherwise
+	defaultQueryType = "A"
+)
+
+// Transports accepted by the test_transport config option for plain DNS entries
+const (
+	transportUDP         = "udp"
+	transportTCP         = "tcp"
+	transportUDPFallback = "udp+tcp" // UDP, retried over TCP when truncated or unanswered
+)
+
+// transportOptions lists the test transports, for the GUI
+var transportOptions = []string{transportUDP, transportTCP, transportUDPFallback}
+
+// testTransport returns the configured test_transport, defaulting to UDP
+func testTransport() string {
+	for _, option := range transportOptions {
+		if config.TestTransport == option {
+			return option
+		}
+	}
+	return transportUDP
+}
+
+// dnsProber sends wire-level queries to one resolver address
+type dnsProber interface {
+	Exchange(ctx context.Context, name string, qtype dnsmessage.Type) (probeResponse, error)
+	Close() error
+}
+
+// pipeliningProber is a prober that can send queries back to back on one
+// connection without waiting for the answers in between
+type pipeliningProber interface {
+	dnsProber
+	ExchangeAll(ctx context.Context, names []string, qtype dnsmessage.Type) ([]probeResponse, []error)
+}
+
+// sessionResumer is a prober over TLS that can drop its connection, so the
+// next query opens a new one and shows whether the session is resumed
+type sessionResumer interface {
+	dnsProber
+	Reconnect()
+}
+
+// exchangeConcurrently sends a query for every name at once through p, which must be
+// safe for concurrent use, and waits for all responses
+func exchangeConcurrently(ctx context.Context, p dnsProber, names []string, qtype dnsmessage.Type) ([]probeResponse, []error) {
+	responses := make([]probeResponse, len(names))
+	errs := make([]error, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			responses[i], errs[i] = p.Exchange(ctx, name, qtype)
+		}()
+	}
+	wg.Wait()
+	return responses, errs
+}
+
+// newProber returns a prober for addr using transport. TCP connections are
+// opened on the first query that needs one, so their handshake can be timed.
+func newProber(ctx context.Context, addr, transport string, timeout time.Duration) (dnsProber, error) {
+	switch transport {
+	case transportTCP:
+		return &tcpProber{addr: addr, timeout: timeout}, nil
+	case transportUDPFallback:
+		udp, err := newUDPProber(ctx, addr, timeout)
+		if err != nil {
+			return nil, err
+		}
+		return &fallbackProber{udp: udp, tcp: &tcpProber{addr: addr, timeout: timeout}}, nil
+	default:
+		return newUDPProber(ctx, addr, timeout)
+	}
+}
+
+// probeQueryTypes maps the test_query_type values to record types.
+// HTTPS (65) is not named by dnsmessage.
+var probeQueryTypes = map[string]dnsmessage.Type{
+	"A":     dnsmessage.TypeA,
+	"AAAA":  dnsmessage.TypeAAAA,
+	"CNAME": dnsmessage.TypeCNAME,
+	"MX":    dnsmessage.TypeMX,
+	"NS":    dnsmessage.TypeNS,
+	"SOA":   dnsmessage.TypeSOA,
+	"TXT":   dnsmessage.TypeTXT,
+	"SRV":   dnsmessage.TypeSRV,
+	"HTTPS": dnsmessage.Type(65),
+}
+
+// probeQueryType returns the configured test_query_type, defaulting to A
+func probeQueryType() dnsmessage.Type {
+	if qtype, ok := probeQueryTypes[strings.ToUpper(config.TestQueryType)]; ok {
+		return qtype
+	}
+	return probeQueryTypes[defaultQueryType]
+}
+
+// probeResponse holds what one answer to a probe looked like on the wire
+type probeResponse struct {
+	RCode              dnsmessage.RCode
+	Answers            int
+	MinTTL             uint32        // lowest TTL among the answer records, 0 without answers
+	Authenticated      bool          // AD: the resolver validated the answer with DNSSEC
+	RecursionAvailable bool          // RA
+	Truncated          bool          // TC: the UDP answer did not fit, even if it was then retried over TCP
+	FellBack           bool          // answered over TCP after UDP was truncated or went unanswered
+	Size               int           // bytes on the wire
+	Latency            time.Duration // query time, without Handshake
+	Handshake          time.Duration // TCP (and TLS) or QUIC connection setup, 0 when an open connection was reused
+	TLS                bool          // the query opened a TLS or QUIC connection
+	Resumed            bool          // that connection resumed an earlier TLS session
+	ZeroRTT            bool          // the query was sent as QUIC 0-RTT early data and accepted
+}
+
+// responseSummary aggregates the probe responses of a resolver for the test results
+type responseSummary struct {
+	Responses          int
+	RCodes             map[string]int // count per rcode, e.g. "NOERROR": 15
+	Answers            int            // answer records in all responses
+	MinTTL             uint32
+	MaxTTL             uint32
+	Authenticated      int
+	RecursionAvailable int
+	Truncated          int // UDP answers with TC set
+	FellBack           int // answers that needed the TCP fallback
+	TLSHandshakes      int
+	Resumed            int // TLS handshakes that resumed a session
+	ZeroRTT            int // resumed sessions that accepted 0-RTT data
+	TotalSize          int
+	MaxSize            int
+}
+
+// add records one response
+func (s *responseSummary) add(r probeResponse) {
+	if s.RCodes == nil {
+		s.RCodes = make(map[string]int)
+	}
+	s.RCodes[rcodeName(r.RCode)]++
+	s.Answers += r.Answers
+	if r.Answers > 0 {
+		if s.MinTTL == 0 || r.MinTTL < s.MinTTL {
+			s.MinTTL = r.MinTTL
+		}
+		if r.MinTTL > s.MaxTTL {
+			s.MaxTTL = r.MinTTL
+		}
+	}
+	if r.Authenticated {
+		s.Authenticated++
+	}
+	if r.RecursionAvailable {
+		s.RecursionAvailable++
+	}
+	if r.Truncated {
+		s.Truncated++
+	}
+	if r.FellBack {
+		s.FellBack++
+	}
+	if r.TLS {
+		s.TLSHandshakes++
+		if r.Resumed {
+			s.Resumed++
+		}
+		if r.ZeroRTT {
+			s.ZeroRTT++
+		}
+	}
+	s.TotalSize += r.Size
+	if r.Size > s.MaxSize {
+		s.MaxSize = r.Size
+	}
+	s.Responses++
+}
+
+// merge adds the responses of another summary, e.g. of another address of the same entry
+func (s *responseSummary) merge(other responseSummary) {
+	if other.Responses == 0 {
+		return
+	}
+	if s.RCodes == nil {
+		s.RCodes = make(map[string]int)
+	}
+	for rcode, count := range other.RCodes {
+		s.RCodes[rcode] += count
+	}
+	if other.Answers > 0 {
+		if s.Answers == 0 || other.MinTTL < s.MinTTL {
+			s.MinTTL = other.MinTTL
+		}
+		if other.MaxTTL > s.MaxTTL {
+			s.MaxTTL = other.MaxTTL
+		}
+	}
+	s.Answers += other.Answers
+	s.Authenticated += other.Authenticated
+	s.RecursionAvailable += other.RecursionAvailable
+	s.Truncated += other.Truncated
+	s.FellBack += other.FellBack
+	s.TLSHandshakes += other.TLSHandshakes
+	s.Resumed += other.Resumed
+	s.ZeroRTT += other.ZeroRTT
+	s.TotalSize += other.TotalSize
+	if other.MaxSize > s.MaxSize {
+		s.MaxSize = other.MaxSize
+	}
+	s.Responses += other.Responses
+}
+
+// String returns a compact summary for the DNS Tester
+func (s responseSummary) String() string {
+	if s.Responses == 0 {
+		return "no responses"
+	}
+	rcodes := make([]string, 0, len(s.RCodes))
+	for rcode, count := range s.RCodes {
+		rcodes = append(rcodes, fmt.Sprintf("%s %d", rcode, count))
+	}
+	sort.Strings(rcodes)
+	resumed := ""
+	if s.TLSHandshakes > 0 {
+		resumed = fmt.Sprintf(" · TLS resumed %d/%d", s.Resumed, s.TLSHandshakes)
+		if s.ZeroRTT > 0 {
+			resumed += fmt.Sprintf(" · 0-RTT %d", s.ZeroRTT)
+		}
+	}
+	return fmt.Sprintf("%s · %.1f answers · TTL %d-%ds · AD %d/%d · RA %d/%d · TC %d · TCP fallback %d · %d B avg, %d B max%s",
+		strings.Join(rcodes, ", "), float64(s.Answers)/float64(s.Responses), s.MinTTL, s.MaxTTL,
+		s.Authenticated, s.Responses, s.RecursionAvailable, s.Responses, s.Truncated, s.FellBack,
+		s.TotalSize/s.Responses, s.MaxSize, resumed)
+}
+
+// rcodeName returns the conventional name of an rcode, such as NOERROR or SERVFAIL
+func rcodeName(rcode dnsmessage.RCode) string {
+	switch rcode {
+	case dnsmessage.RCodeSuccess:
+		return "NOERROR"
+	case dnsmessage.RCodeFormatError:
+		return "FORMERR"
+	case dnsmessage.RCodeServerFailure:
+		return "SERVFAIL"
+	case dnsmessage.RCodeNameError:
+		return "NXDOMAIN"
+	case dnsmessage.RCodeNotImplemented:
+		return "NOTIMP"
+	case dnsmessage.RCodeRefused:
+		return "REFUSED"
+	default:
+		return fmt.Sprintf("RCODE%d", rcode)
+	}
+}
+
+// udpProber sends wire-level queries to one resolver over a single UDP socket,
+// which is reused for every query
+type udpProber struct {
+	conn *net.UDPConn
+	buf  []byte
+}
+
+func newUDPProber(ctx context.Context, addr string, timeout time.Duration) (*udpProber, error) {
+	d := net.Dialer{Timeout: timeout}
+	conn, err := d.DialContext(ctx, "udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	udpConn, ok := conn.(*net.UDPConn)
+	if !ok {
+		// The type mismatch is the error to report
+		_ = conn.Close()
+		return nil, fmt.Errorf("unexpected connection type %T for %s", conn, addr)
+	}
+	return &udpProber{conn: udpConn, buf: make([]byte, 65535)}, nil
+}
+
+func (p *udpProber) Close() error {
+	return p.conn.Close()
+}
+
+// Exchange sends one query and waits for the matching response until ctx is done.
+// Late replies to earlier queries on the same socket are skipped.
+func (p *udpProber) Exchange(ctx context.Context, name string, qtype dnsmessage.Type) (probeResponse, error) {
+	id := uint16(rand.Uint32())
+	question, query, err := buildProbeQuery(id, name, qtype)
+	if err != nil {
+		return probeResponse{}, err
+	}
+
+	var response probeResponse
+	start := time.Now()
+	err = p.roundTrip(ctx, query, func(msg []byte) bool {
+		response, err = parseProbeResponse(msg, id, question)
+		return err == nil
+	})
+	if err != nil {
+		return probeResponse{}, err
+	}
+	response.Latency = time.Since(start)
+	return response, nil
+}
+
+// roundTrip sends packet and reads datagrams until accept takes one or ctx is done.
+// accept must not keep the datagram, its buffer is reused.
+func (p *udpProber) roundTrip(ctx context.Context, packet []byte, accept func(msg []byte) bool) error {
+	// Without a deadline on ctx this is the zero time, which clears the previous one
+	deadline, _ := ctx.Deadline()
+	if err := p.conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+	// Unblock the read if the benchmark is stopped before the deadline
+	stop := context.AfterFunc(ctx, func() {
+		// Only fails on a closed socket, whose read fails anyway
+		_ = p.conn.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	if _, err := p.conn.Write(packet); err != nil {
+		return err
+	}
+	for {
+		n, err := p.conn.Read(p.buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		if accept(p.buf[:n]) {
+			return nil
+		}
+	}
+}
+
+// buildProbeQuery packs a recursive query with EDNS0. AD is set so validating
+// resolvers report whether the answer is authenticated (RFC 6840).
+func buildProbeQuery(id uint16, name string, qtype dnsmessage.Type) (dnsmessage.Question, []byte, error) {
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	qname, err := dnsmessage.NewName(name)
+	if err != nil {
+		return dnsmessage.Question{}, nil, fmt.Errorf("invalid name %q: %v", name, err)
+	}
+	question := dnsmessage.Question{Name: qname, Type: qtype, Class: dnsmessage.ClassINET}
+
+	b := dnsmessage.NewBuilder(make([]byte, 0, 512), dnsmessage.Header{
+		ID:               id,
+		RecursionDesired: true,
+		AuthenticData:    true,
+	})
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return question, nil, err
+	}
+	if err := b.Question(question); err != nil {
+		return question, nil, err
+	}
+	if err := b.StartAdditionals(); err != nil {
+		return question, nil, err
+	}
+	var opt dnsmessage.ResourceHeader
+	if err := opt.SetEDNS0(probeUDPPayloadSize, dnsmessage.RCodeSuccess, false); err != nil {
+		return question, nil, err
+	}
+	if err := b.OPTResource(opt, dnsmessage.OPTResource{}); err != nil {
+		return question, nil, err
+	}
+	msg, err := b.Finish()
+	return question, msg, err
+}
+
+// parseProbeResponse checks that msg answers our query and extracts the fields we report
+func parseProbeResponse(msg []byte, id uint16, question dnsmessage.Question) (probeResponse, error) {
+	var p dnsmessage.Parser
+	header, err := p.Start(msg)
+	if err != nil {
+		return probeResponse{}, err
+	}
+	if !header.Response || header.ID != id {
+		return probeResponse{}, fmt.Errorf("not a response to query %d", id)
+	}
+	q, err := p.Question()
+	if err != nil {
+		return probeResponse{}, err
+	}
+	if q.Type != question.Type || q.Class != question.Class || !strings.EqualFold(q.Name.String(), question.Name.String()) {
+		return probeResponse{}, fmt.Errorf("response is for a different question")
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return probeResponse{}, err
+	}
+
+	response := probeResponse{
+		RCode:              header.RCode,
+		Authenticated:      header.AuthenticData,
+		RecursionAvailable: header.RecursionAvailable,
+		Truncated:          header.Truncated,
+		Size:               len(msg),
+	}
+	for {
+		h, err := p.AnswerHeader()
+		if err == dnsmessage.ErrSectionDone {
+			break
+		}
+		if err != nil {
+			// A truncated message may end mid-section; keep what was parsed
+			break
+		}
+		if response.Answers == 0 || h.TTL < response.MinTTL {
+			response.MinTTL = h.TTL
+		}
+		response.Answers++
+		if err := p.SkipAnswer(); err != nil {
+			break
+		}
+	}
+	return response, nil
+}
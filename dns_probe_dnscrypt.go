@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	crand "crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/nacl/box"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DNSCrypt v2 wire constants (https://dnscrypt.info/protocol)
+const (
+	dnscryptCertMagic     = "DNSC"
+	dnscryptResolverMagic = "r6fnvWj8"
+	// dnscryptXSalsa20Poly1305 is the es-version of certificates for X25519-XSalsa20Poly1305,
+	// the construction every resolver supports
+	dnscryptXSalsa20Poly1305 = 0x0001
+	dnscryptCertSize         = 124
+	dnscryptNonceSize        = 24
+	dnscryptPadBlock         = 64
+	// dnscryptMinQueryLen is the length UDP queries are padded to at least, until a
+	// response is truncated; resolvers answer with no more bytes than the query had
+	dnscryptMinQueryLen = 256
+	dnscryptMaxQueryLen = probeUDPPayloadSize / dnscryptPadBlock * dnscryptPadBlock
+)
+
+// dnscryptCert is the part of a resolver certificate queries are encrypted with
+type dnscryptCert struct {
+	Serial      uint32
+	ResolverKey [32]byte
+	ClientMagic [8]byte
+}
+
+// dnscryptProber sends encrypted queries to a DNSCrypt v2 resolver over UDP. The
+// resolver's certificate is fetched over plain DNS before the first query, and
+// the time that takes is reported as its Handshake.
+type dnscryptProber struct {
+	udp   *udpProber
+	stamp *dnscryptStamp
+
+	cert        *dnscryptCert
+	publicKey   *[32]byte
+	sharedKey   [32]byte
+	minQueryLen int
+}
+
+func newDNSCryptProber(ctx context.Context, addr string, stamp *dnscryptStamp, timeout time.Duration) (*dnscryptProber, error) {
+	udp, err := newUDPProber(ctx, addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &dnscryptProber{udp: udp, stamp: stamp, minQueryLen: dnscryptMinQueryLen}, nil
+}
+
+func (p *dnscryptProber) Close() error {
+	return p.udp.Close()
+}
+
+// Exchange sends one encrypted query and waits for the matching response until ctx is done
+func (p *dnscryptProber) Exchange(ctx context.Context, name string, qtype dnsmessage.Type) (probeResponse, error) {
+	var handshake time.Duration
+	if p.cert == nil {
+		start := time.Now()
+		if err := p.fetchCert(ctx); err != nil {
+			if ctx.Err() != nil {
+				return probeResponse{}, ctx.Err()
+			}
+			return probeResponse{}, fmt.Errorf("DNSCrypt certificate: %v", err)
+		}
+		handshake = time.Since(start)
+	}
+
+	id := uint16(rand.Uint32())
+	question, query, err := buildProbeQuery(id, name, qtype)
+	if err != nil {
+		return probeResponse{}, err
+	}
+
+	start := time.Now()
+	response, err := p.exchange(ctx, id, question, query)
+	if err == nil && response.Truncated && p.minQueryLen < dnscryptMaxQueryLen {
+		// Pad this and every later query to the largest size to get the full answer
+		p.minQueryLen = dnscryptMaxQueryLen
+		response, err = p.exchange(ctx, id, question, query)
+		response.Truncated = true
+	}
+	if err != nil {
+		return probeResponse{}, err
+	}
+	response.Latency = time.Since(start)
+	response.Handshake = handshake
+	return response, nil
+}
+
+// exchange sends query encrypted and returns the decrypted response
+func (p *dnscryptProber) exchange(ctx context.Context, id uint16, question dnsmessage.Question, query []byte) (probeResponse, error) {
+	packet, nonce, err := p.encrypt(query)
+	if err != nil {
+		return probeResponse{}, err
+	}
+	var response probeResponse
+	err = p.udp.roundTrip(ctx, packet, func(msg []byte) bool {
+		plain, err := p.decrypt(msg, nonce)
+		if err != nil {
+			return false
+		}
+		response, err = parseProbeResponse(plain, id, question)
+		response.Size = len(msg)
+		return err == nil
+	})
+	return response, err
+}
+
+// fetchCert asks the resolver for the certificates of the provider, keeps the
+// newest one signed by the stamp's key and derives the key to encrypt with
+func (p *dnscryptProber) fetchCert(ctx context.Context) error {
+	id := uint16(rand.Uint32())
+	question, query, err := buildProbeQuery(id, p.stamp.ProviderName, dnsmessage.TypeTXT)
+	if err != nil {
+		return err
+	}
+	var answer []byte
+	err = p.udp.roundTrip(ctx, query, func(msg []byte) bool {
+		if _, err := parseProbeResponse(msg, id, question); err != nil {
+			return false
+		}
+		answer = append([]byte(nil), msg...)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	var m dnsmessage.Message
+	if err := m.Unpack(answer); err != nil {
+		return err
+	}
+
+	var best *dnscryptCert
+	var lastErr error
+	now := time.Now()
+	for _, answer := range m.Answers {
+		txt, ok := answer.Body.(*dnsmessage.TXTResource)
+		if !ok {
+			continue
+		}
+		cert, err := parseDNSCryptCert([]byte(strings.Join(txt.TXT, "")), p.stamp.PublicKey, now)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if best == nil || cert.Serial > best.Serial {
+			best = &cert
+		}
+	}
+	if best == nil {
+		if lastErr == nil {
+			lastErr = fmt.Errorf("no certificate published for %s", p.stamp.ProviderName)
+		}
+		return lastErr
+	}
+
+	publicKey, secretKey, err := box.GenerateKey(crand.Reader)
+	if err != nil {
+		return err
+	}
+	box.Precompute(&p.sharedKey, &best.ResolverKey, secretKey)
+	p.publicKey = publicKey
+	p.cert = best
+	return nil
+}
+
+// parseDNSCryptCert checks a certificate from a TXT record against the provider's
+// key and the time. Only X25519-XSalsa20Poly1305 certificates are accepted.
+func parseDNSCryptCert(data []byte, providerKey ed25519.PublicKey, now time.Time) (dnscryptCert, error) {
+	if len(data) < dnscryptCertSize || string(data[:4]) != dnscryptCertMagic {
+		return dnscryptCert{}, fmt.Errorf("invalid certificate")
+	}
+	if version := binary.BigEndian.Uint16(data[4:6]); version != dnscryptXSalsa20Poly1305 {
+		return dnscryptCert{}, fmt.Errorf("unsupported certificate version %d", version)
+	}
+	signature, signed := data[8:72], data[72:]
+	if !ed25519.Verify(providerKey, signed, signature) {
+		return dnscryptCert{}, fmt.Errorf("certificate signature does not match the stamp's key")
+	}
+
+	var cert dnscryptCert
+	copy(cert.ResolverKey[:], signed[0:32])
+	copy(cert.ClientMagic[:], signed[32:40])
+	cert.Serial = binary.BigEndian.Uint32(signed[40:44])
+	validFrom := time.Unix(int64(binary.BigEndian.Uint32(signed[44:48])), 0)
+	validUntil := time.Unix(int64(binary.BigEndian.Uint32(signed[48:52])), 0)
+	if now.Before(validFrom) || now.After(validUntil) {
+		return dnscryptCert{}, fmt.Errorf("certificate is only valid from %s to %s",
+			validFrom.Format(time.RFC3339), validUntil.Format(time.RFC3339))
+	}
+	return cert, nil
+}
+
+// encrypt pads and encrypts query, returning the packet to send and its nonce
+func (p *dnscryptProber) encrypt(query []byte) ([]byte, *[dnscryptNonceSize]byte, error) {
+	// The client half of the nonce is random, the resolver fills in the rest in its response
+	nonce := new([dnscryptNonceSize]byte)
+	if _, err := crand.Read(nonce[:dnscryptNonceSize/2]); err != nil {
+		return nil, nil, err
+	}
+
+	// ISO/IEC 7816-4 padding: 0x80, then zeros up to a multiple of the block size
+	size := (len(query) + 1 + dnscryptPadBlock - 1) / dnscryptPadBlock * dnscryptPadBlock
+	if size < p.minQueryLen {
+		size = p.minQueryLen
+	}
+	padded := make([]byte, size)
+	copy(padded, query)
+	padded[len(query)] = 0x80
+
+	packet := make([]byte, 0, len(p.cert.ClientMagic)+len(p.publicKey)+dnscryptNonceSize/2+box.Overhead+size)
+	packet = append(packet, p.cert.ClientMagic[:]...)
+	packet = append(packet, p.publicKey[:]...)
+	packet = append(packet, nonce[:dnscryptNonceSize/2]...)
+	return box.SealAfterPrecomputation(packet, padded, nonce, &p.sharedKey), nonce, nil
+}
+
+// decrypt opens a response to the query sent with nonce and strips its padding
+func (p *dnscryptProber) decrypt(msg []byte, nonce *[dnscryptNonceSize]byte) ([]byte, error) {
+	header := len(dnscryptResolverMagic) + dnscryptNonceSize
+	if len(msg) < header+box.Overhead || string(msg[:len(dnscryptResolverMagic)]) != dnscryptResolverMagic {
+		return nil, fmt.Errorf("not a DNSCrypt response")
+	}
+	var responseNonce [dnscryptNonceSize]byte
+	copy(responseNonce[:], msg[len(dnscryptResolverMagic):header])
+	if !bytes.Equal(responseNonce[:dnscryptNonceSize/2], nonce[:dnscryptNonceSize/2]) {
+		return nil, fmt.Errorf("response to another query")
+	}
+	plain, ok := box.OpenAfterPrecomputation(nil, msg[header:], &responseNonce, &p.sharedKey)
+	if !ok {
+		return nil, fmt.Errorf("response failed to decrypt")
+	}
+	end := bytes.LastIndexByte(plain, 0x80)
+	if end < 0 || len(bytes.Trim(plain[end+1:], "\x00")) != 0 {
+		return nil, fmt.Errorf("invalid padding in response")
+	}
+	return plain[:end], nil
+}
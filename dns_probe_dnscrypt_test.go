@@ -0,0 +1,69 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// buildDNSCryptCert signs a certificate for resolverKey with the provider's key
+func buildDNSCryptCert(provider ed25519.PrivateKey, version uint16, serial uint32, validFrom, validUntil time.Time) []byte {
+	signed := make([]byte, 32, 52)
+	for i := range signed {
+		signed[i] = byte(i)
+	}
+	signed = append(signed, "magic123"...)
+	signed = binary.BigEndian.AppendUint32(signed, serial)
+	signed = binary.BigEndian.AppendUint32(signed, uint32(validFrom.Unix()))
+	signed = binary.BigEndian.AppendUint32(signed, uint32(validUntil.Unix()))
+
+	cert := []byte(dnscryptCertMagic)
+	cert = binary.BigEndian.AppendUint16(cert, version)
+	cert = append(cert, 0, 0)
+	cert = append(cert, ed25519.Sign(provider, signed)...)
+	return append(cert, signed...)
+}
+
+func TestParseDNSCryptCert(t *testing.T) {
+	publicKey, provider, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 0)
+	hour := time.Hour
+
+	valid := buildDNSCryptCert(provider, dnscryptXSalsa20Poly1305, 42, now.Add(-hour), now.Add(hour))
+	cert, err := parseDNSCryptCert(valid, publicKey, now)
+	if err != nil {
+		t.Fatalf("parseDNSCryptCert: %v", err)
+	}
+	if cert.Serial != 42 || string(cert.ClientMagic[:]) != "magic123" || cert.ResolverKey[31] != 31 {
+		t.Errorf("parseDNSCryptCert = %+v", cert)
+	}
+
+	tampered := append([]byte(nil), valid...)
+	tampered[len(tampered)-1] ^= 1
+	invalid := map[string]struct {
+		data []byte
+		key  ed25519.PublicKey
+	}{
+		"empty":          {nil, publicKey},
+		"short":          {valid[:dnscryptCertSize-1], publicKey},
+		"bad magic":      {append([]byte("DNSX"), valid[4:]...), publicKey},
+		"XChaCha20":      {buildDNSCryptCert(provider, 0x0002, 42, now.Add(-hour), now.Add(hour)), publicKey},
+		"tampered":       {tampered, publicKey},
+		"other provider": {valid, otherKey},
+		"expired":        {buildDNSCryptCert(provider, dnscryptXSalsa20Poly1305, 42, now.Add(-2*hour), now.Add(-hour)), publicKey},
+		"not yet valid":  {buildDNSCryptCert(provider, dnscryptXSalsa20Poly1305, 42, now.Add(hour), now.Add(2*hour)), publicKey},
+	}
+	for name, tt := range invalid {
+		if _, err := parseDNSCryptCert(tt.data, tt.key, now); err == nil {
+			t.Errorf("%s: parseDNSCryptCert succeeded", name)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dohContentType is the media type of DNS wire messages over HTTPS (RFC 8484)
+const dohContentType = "application/dns-message"
+
+// dohProber sends wire-format queries to a DNS-over-HTTPS endpoint with one
+// HTTP method. Its HTTP/2 connection is kept open between queries.
+type dohProber struct {
+	endpoint  string
+	method    string // http.MethodGet or http.MethodPost
+	transport *http.Transport
+	client    *http.Client
+}
+
+func newDoHProber(endpoint *url.URL, method string, timeout time.Duration) *dohProber {
+	transport := &http.Transport{
+		TLSClientConfig:     &tls.Config{ClientSessionCache: tls.NewLRUClientSessionCache(1)},
+		ForceAttemptHTTP2:   true,
+		TLSHandshakeTimeout: timeout,
+		MaxIdleConnsPerHost: 1,
+	}
+	return &dohProber{
+		endpoint:  endpoint.String(),
+		method:    method,
+		transport: transport,
+		client:    &http.Client{Transport: transport},
+	}
+}
+
+func (p *dohProber) Close() error {
+	p.transport.CloseIdleConnections()
+	return nil
+}
+
+// Reconnect closes the connection, so the next query shows whether the endpoint
+// resumes the TLS session
+func (p *dohProber) Reconnect() {
+	p.transport.CloseIdleConnections()
+}
+
+// Exchange sends one query and reads the response until ctx is done. Latency runs
+// from getting a connection to the end of the response; the TCP and TLS setup of
+// a new connection is reported as Handshake, and the lookup of the endpoint's
+// host name is in neither.
+func (p *dohProber) Exchange(ctx context.Context, name string, qtype dnsmessage.Type) (probeResponse, error) {
+	// ID 0 keeps GET responses cacheable by HTTP caches (RFC 8484 section 4.1)
+	question, query, err := buildProbeQuery(0, name, qtype)
+	if err != nil {
+		return probeResponse{}, err
+	}
+
+	var req *http.Request
+	if p.method == http.MethodGet {
+		sep := "?"
+		if strings.Contains(p.endpoint, "?") {
+			sep = "&"
+		}
+		target := p.endpoint + sep + "dns=" + base64.RawURLEncoding.EncodeToString(query)
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	} else {
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, p.endpoint, bytes.NewReader(query))
+		if err == nil {
+			req.Header.Set("Content-Type", dohContentType)
+		}
+	}
+	if err != nil {
+		return probeResponse{}, err
+	}
+	req.Header.Set("Accept", dohContentType)
+
+	var connectStart, tlsDone, gotConn time.Time
+	resumed := false
+	trace := &httptrace.ClientTrace{
+		ConnectStart: func(string, string) {
+			if connectStart.IsZero() {
+				connectStart = time.Now()
+			}
+		},
+		TLSHandshakeDone: func(state tls.ConnectionState, _ error) {
+			tlsDone = time.Now()
+			resumed = state.DidResume
+		},
+		GotConn: func(httptrace.GotConnInfo) { gotConn = time.Now() },
+	}
+	req = req.WithContext(httptrace.WithClientTrace(ctx, trace))
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return probeResponse{}, ctx.Err()
+		}
+		return probeResponse{}, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 65535))
+	end := time.Now()
+	if err != nil {
+		return probeResponse{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return probeResponse{}, fmt.Errorf("HTTP %s", resp.Status)
+	}
+
+	response, err := parseProbeResponse(body, 0, question)
+	if err != nil {
+		return probeResponse{}, err
+	}
+	response.Latency = end.Sub(gotConn)
+	if !connectStart.IsZero() && !tlsDone.IsZero() {
+		response.Handshake = tlsDone.Sub(connectStart)
+		response.TLS = true
+		response.Resumed = resumed
+	}
+	return response, nil
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// doqALPN is the TLS application protocol of DNS-over-QUIC (RFC 9250)
+const doqALPN = "doq"
+
+// DNS-over-QUIC error codes (RFC 9250 section 4.3)
+const (
+	doqNoError          = 0x0
+	doqRequestCancelled = 0x3
+)
+
+// doqProber sends wire-level queries to a DNS-over-QUIC resolver (RFC 9250). One
+// connection is kept open and every query gets a stream of its own, so queries
+// never wait for each other. Sessions are cached, so a new connection can resume
+// the previous session and send its first query as 0-RTT early data.
+type doqProber struct {
+	addr string
+	tls  *tls.Config
+	quic *quic.Config
+
+	mu   sync.Mutex
+	conn quic.EarlyConnection
+	next quic.Connection // conn once it rejected our 0-RTT data, nil until then
+}
+
+func newDoQProber(addr, serverName string, ip net.IP, timeout time.Duration) *doqProber {
+	if serverName == "" {
+		serverName = ip.String()
+	}
+	return &doqProber{
+		addr: addr,
+		tls: &tls.Config{
+			ServerName:         serverName,
+			NextProtos:         []string{doqALPN},
+			ClientSessionCache: tls.NewLRUClientSessionCache(1),
+		},
+		quic: &quic.Config{
+			HandshakeIdleTimeout: timeout,
+			TokenStore:           quic.NewLRUTokenStore(1, 1),
+		},
+	}
+}
+
+func (p *doqProber) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn == nil {
+		return nil
+	}
+	err := p.conn.CloseWithError(doqNoError, "")
+	p.conn, p.next = nil, nil
+	return err
+}
+
+// Reconnect closes the connection, so the next query shows whether the resolver
+// resumes the session and accepts 0-RTT data
+func (p *doqProber) Reconnect() {
+	// The next query opens a new connection either way
+	_ = p.Close()
+}
+
+// Exchange sends one query on a new stream and waits for its response until ctx
+// is done. It is safe for concurrent use.
+func (p *doqProber) Exchange(ctx context.Context, name string, qtype dnsmessage.Type) (probeResponse, error) {
+	// The ID must be 0, the stream identifies the query (RFC 9250 section 4.2.1)
+	question, query, err := buildProbeQuery(0, name, qtype)
+	if err != nil {
+		return probeResponse{}, err
+	}
+
+	early, conn, handshake, err := p.connect(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return probeResponse{}, ctx.Err()
+		}
+		return probeResponse{}, err
+	}
+
+	start := time.Now()
+	data, err := doqRoundTrip(ctx, conn, query)
+	if errors.Is(err, quic.Err0RTTRejected) {
+		// The early data was dropped, send the query again after the full handshake
+		if conn, err = p.afterRejection(ctx, early); err == nil {
+			data, err = doqRoundTrip(ctx, conn, query)
+		}
+	}
+	end := time.Now()
+	if err != nil {
+		if ctx.Err() != nil {
+			return probeResponse{}, ctx.Err()
+		}
+		if early.Context().Err() != nil {
+			p.drop(early)
+		}
+		return probeResponse{}, err
+	}
+
+	response, err := parseProbeResponse(data, 0, question)
+	if err != nil {
+		return probeResponse{}, err
+	}
+	response.Latency = end.Sub(start)
+	if handshake > 0 {
+		// Resumption and 0-RTT are only known once the handshake is done, which the
+		// response may have overtaken
+		select {
+		case <-early.HandshakeComplete():
+		case <-ctx.Done():
+			return probeResponse{}, ctx.Err()
+		}
+		state := early.ConnectionState()
+		response.Handshake = handshake
+		response.TLS = true
+		response.Resumed = state.TLS.DidResume
+		response.ZeroRTT = state.Used0RTT
+	}
+	return response, nil
+}
+
+// ExchangeAll sends a query for every name, each on its own stream, and waits for all responses
+func (p *doqProber) ExchangeAll(ctx context.Context, names []string, qtype dnsmessage.Type) ([]probeResponse, []error) {
+	return exchangeConcurrently(ctx, p, names, qtype)
+}
+
+// connect returns the open connection, and the connection to send on, dialing a
+// new one if there is none. For a new connection the time the dial took is
+// returned too: until the connection can send, which is right away with 0-RTT.
+func (p *doqProber) connect(ctx context.Context) (quic.EarlyConnection, quic.Connection, time.Duration, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn != nil {
+		if p.next != nil {
+			return p.conn, p.next, 0, nil
+		}
+		return p.conn, p.conn, 0, nil
+	}
+	start := time.Now()
+	conn, err := quic.DialAddrEarly(ctx, p.addr, p.tls, p.quic)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	p.conn = conn
+	return conn, conn, time.Since(start), nil
+}
+
+// afterRejection waits for the handshake of a connection whose 0-RTT data the
+// resolver rejected and returns the connection to send on from then on
+func (p *doqProber) afterRejection(ctx context.Context, early quic.EarlyConnection) (quic.Connection, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn != early {
+		return nil, fmt.Errorf("connection closed after 0-RTT was rejected")
+	}
+	if p.next == nil {
+		next, err := early.NextConnection(ctx)
+		if err != nil {
+			return nil, err
+		}
+		p.next = next
+	}
+	return p.next, nil
+}
+
+// drop forgets conn after it failed, unless it was already replaced
+func (p *doqProber) drop(conn quic.EarlyConnection) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn == conn {
+		p.conn, p.next = nil, nil
+	}
+}
+
+// doqRoundTrip sends query on a new stream of conn and reads the response
+func doqRoundTrip(ctx context.Context, conn quic.Connection, query []byte) ([]byte, error) {
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	deadline, _ := ctx.Deadline()
+	if err := stream.SetDeadline(deadline); err != nil {
+		stream.CancelWrite(doqRequestCancelled)
+		stream.CancelRead(doqRequestCancelled)
+		return nil, err
+	}
+	stop := context.AfterFunc(ctx, func() {
+		stream.CancelWrite(doqRequestCancelled)
+		stream.CancelRead(doqRequestCancelled)
+	})
+	defer stop()
+
+	msg := make([]byte, 2+len(query))
+	binary.BigEndian.PutUint16(msg, uint16(len(query)))
+	copy(msg[2:], query)
+	if _, err := stream.Write(msg); err != nil {
+		stream.CancelRead(doqRequestCancelled)
+		return nil, err
+	}
+	// Closing the sending side tells the resolver the query is complete
+	if err := stream.Close(); err != nil {
+		stream.CancelRead(doqRequestCancelled)
+		return nil, err
+	}
+
+	var length [2]byte
+	if _, err := io.ReadFull(stream, length[:]); err != nil {
+		stream.CancelRead(doqRequestCancelled)
+		return nil, err
+	}
+	data := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(stream, data); err != nil {
+		stream.CancelRead(doqRequestCancelled)
+		return nil, err
+	}
+	return data, nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// tcpProber sends wire-level queries to one resolver over TCP (RFC 7766). The
+// connection is kept open between queries and redialed when it breaks.
+type tcpProber struct {
+	addr    string
+	timeout time.Duration
+	conn    net.Conn
+	buf     []byte
+}
+
+func (p *tcpProber) Close() error {
+	if p.conn == nil {
+		return nil
+	}
+	err := p.conn.Close()
+	p.conn = nil
+	return err
+}
+
+// Exchange sends one query and waits for the matching response until ctx is done.
+// The time to open a new connection is reported as Handshake, apart from Latency.
+func (p *tcpProber) Exchange(ctx context.Context, name string, qtype dnsmessage.Type) (probeResponse, error) {
+	id := uint16(rand.Uint32())
+	question, query, err := buildProbeQuery(id, name, qtype)
+	if err != nil {
+		return probeResponse{}, err
+	}
+
+	reused := p.conn != nil
+	response, err := p.exchange(ctx, id, question, query)
+	if err != nil && reused && ctx.Err() == nil {
+		// Resolvers close idle connections; try once more on a fresh one
+		response, err = p.exchange(ctx, id, question, query)
+	}
+	return response, err
+}
+
+func (p *tcpProber) exchange(ctx context.Context, id uint16, question dnsmessage.Question, query []byte) (probeResponse, error) {
+	var handshake time.Duration
+	if p.conn == nil {
+		d := net.Dialer{Timeout: p.timeout}
+		start := time.Now()
+		conn, err := d.DialContext(ctx, "tcp", p.addr)
+		if err != nil {
+			return probeResponse{}, err
+		}
+		handshake = time.Since(start)
+		p.conn = conn
+		if p.buf == nil {
+			p.buf = make([]byte, 65535)
+		}
+	}
+	conn := p.conn
+
+	// fail drops the connection, whose framing can't be trusted after an error
+	fail := func(err error) (probeResponse, error) {
+		// The error that broke the connection is the one to report
+		_ = p.Close()
+		if ctx.Err() != nil {
+			return probeResponse{}, ctx.Err()
+		}
+		return probeResponse{}, err
+	}
+
+	deadline, _ := ctx.Deadline()
+	if err := conn.SetDeadline(deadline); err != nil {
+		return fail(err)
+	}
+	// Unblock the read if the benchmark is stopped before the deadline
+	stop := context.AfterFunc(ctx, func() {
+		// Only fails on a closed connection, whose read fails anyway
+		_ = conn.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	msg := make([]byte, 2+len(query))
+	binary.BigEndian.PutUint16(msg, uint16(len(query)))
+	copy(msg[2:], query)
+
+	start := time.Now()
+	if _, err := conn.Write(msg); err != nil {
+		return fail(err)
+	}
+	for {
+		var length [2]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return fail(err)
+		}
+		n := int(binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(conn, p.buf[:n]); err != nil {
+			return fail(err)
+		}
+		// Late answers to queries that timed out earlier on this connection are skipped
+		response, err := parseProbeResponse(p.buf[:n], id, question)
+		if err != nil {
+			continue
+		}
+		response.Latency = time.Since(start)
+		response.Handshake = handshake
+		return response, nil
+	}
+}
+
+// fallbackProber queries over UDP and repeats the query over TCP when the UDP
+// answer is truncated or doesn't arrive in the first half of the time allowed,
+// like a stub resolver would
+type fallbackProber struct {
+	udp *udpProber
+	tcp *tcpProber
+}
+
+func (p *fallbackProber) Close() error {
+	return errors.Join(p.tcp.Close(), p.udp.Close())
+}
+
+// Exchange returns the UDP answer, or the TCP one with FellBack set. The time lost
+// on UDP counts towards the latency of a TCP answer; the handshake does not.
+func (p *fallbackProber) Exchange(ctx context.Context, name string, qtype dnsmessage.Type) (probeResponse, error) {
+	start := time.Now()
+	udpCtx, cancel := ctx, context.CancelFunc(func() {})
+	if deadline, ok := ctx.Deadline(); ok {
+		udpCtx, cancel = context.WithDeadline(ctx, start.Add(time.Until(deadline)/2))
+	}
+	response, udpErr := p.udp.Exchange(udpCtx, name, qtype)
+	cancel()
+	if udpErr == nil && !response.Truncated {
+		return response, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return probeResponse{}, err
+	}
+
+	tcpResponse, err := p.tcp.Exchange(ctx, name, qtype)
+	if err != nil {
+		if udpErr == nil {
+			return probeResponse{}, fmt.Errorf("truncated over UDP, TCP failed: %v", err)
+		}
+		return probeResponse{}, fmt.Errorf("UDP: %v, TCP: %v", udpErr, err)
+	}
+	tcpResponse.Truncated = udpErr == nil
+	tcpResponse.FellBack = true
+	tcpResponse.Latency = time.Since(start) - tcpResponse.Handshake
+	return tcpResponse, nil
+}
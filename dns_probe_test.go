@@ -0,0 +1,128 @@
+package main
+
+import (
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// buildProbeResponse answers question with an A record per TTL
+func buildProbeResponse(t *testing.T, header dnsmessage.Header, question dnsmessage.Question, ttls ...uint32) []byte {
+	t.Helper()
+	b := dnsmessage.NewBuilder(nil, header)
+	if err := b.StartQuestions(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Question(question); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.StartAnswers(); err != nil {
+		t.Fatal(err)
+	}
+	for i, ttl := range ttls {
+		h := dnsmessage.ResourceHeader{Name: question.Name, Class: dnsmessage.ClassINET, TTL: ttl}
+		if err := b.AResource(h, dnsmessage.AResource{A: [4]byte{192, 0, 2, byte(i + 1)}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	msg, err := b.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func TestParseProbeResponse(t *testing.T) {
+	question, query, err := buildProbeQuery(0x1234, "Example.com", dnsmessage.TypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if question.Name.String() != "Example.com." {
+		t.Fatalf("question name = %q, want a trailing dot", question.Name)
+	}
+	reply := dnsmessage.Header{ID: 0x1234, Response: true, RecursionAvailable: true}
+	lower := question
+	lower.Name = dnsmessage.MustNewName("example.com.")
+	full := buildProbeResponse(t, reply, question, 300, 60, 120)
+
+	tests := []struct {
+		name string
+		msg  []byte
+		want probeResponse
+	}{
+		{
+			name: "answers",
+			msg:  full,
+			want: probeResponse{Answers: 3, MinTTL: 60, RecursionAvailable: true},
+		},
+		{
+			name: "name case differs",
+			msg:  buildProbeResponse(t, reply, lower, 30),
+			want: probeResponse{Answers: 1, MinTTL: 30, RecursionAvailable: true},
+		},
+		{
+			name: "NXDOMAIN with AD",
+			msg: buildProbeResponse(t, dnsmessage.Header{
+				ID: 0x1234, Response: true, RCode: dnsmessage.RCodeNameError, AuthenticData: true,
+			}, question),
+			want: probeResponse{RCode: dnsmessage.RCodeNameError, Authenticated: true},
+		},
+		{
+			name: "truncated mid-answer",
+			// 16-byte A records; cut the last one in its resource header
+			msg:  full[:len(full)-14],
+			want: probeResponse{Answers: 2, MinTTL: 60, RecursionAvailable: true},
+		},
+		{
+			name: "TC flag",
+			msg:  buildProbeResponse(t, dnsmessage.Header{ID: 0x1234, Response: true, Truncated: true}, question),
+			want: probeResponse{Truncated: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseProbeResponse(tt.msg, 0x1234, question)
+			if err != nil {
+				t.Fatalf("parseProbeResponse: %v", err)
+			}
+			tt.want.Size = len(tt.msg)
+			if got != tt.want {
+				t.Errorf("parseProbeResponse =\n%+v, want\n%+v", got, tt.want)
+			}
+		})
+	}
+
+	aaaa := question
+	aaaa.Type = dnsmessage.TypeAAAA
+	other := question
+	other.Name = dnsmessage.MustNewName("example.net.")
+	invalid := []struct {
+		name string
+		msg  []byte
+	}{
+		{"empty", nil},
+		{"short header", full[:5]},
+		{"header only", full[:12]},
+		{"query echoed back", query},
+		{"wrong ID", buildProbeResponse(t, dnsmessage.Header{ID: 0x4321, Response: true}, question)},
+		{"wrong type", buildProbeResponse(t, reply, aaaa)},
+		{"wrong name", buildProbeResponse(t, reply, other)},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := parseProbeResponse(tt.msg, 0x1234, question); err == nil {
+				t.Errorf("parseProbeResponse = %+v, want an error", got)
+			}
+		})
+	}
+}
+
+func TestBuildProbeQueryInvalidName(t *testing.T) {
+	long := make([]byte, 64)
+	for i := range long {
+		long[i] = 'a'
+	}
+	if _, _, err := buildProbeQuery(1, string(long)+".com", dnsmessage.TypeA); err == nil {
+		t.Error("buildProbeQuery accepted a 64-byte label")
+	}
+}
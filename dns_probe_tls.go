@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dotProber sends wire-level queries to a DNS-over-TLS resolver (RFC 7858). One
+// connection is kept open and shared: queries may be sent while earlier ones are
+// still unanswered, and a reader matches the responses to them by ID. Sessions
+// are cached, so a new connection can resume the previous TLS session.
+type dotProber struct {
+	addr    string
+	timeout time.Duration
+	tls     *tls.Config
+
+	mu      sync.Mutex
+	conn    *tls.Conn
+	pending map[uint16]chan []byte
+}
+
+func newDoTProber(addr, serverName string, ip net.IP, timeout time.Duration) *dotProber {
+	if serverName == "" {
+		// crypto/tls checks the certificate's IP addresses when ServerName is an IP
+		serverName = ip.String()
+	}
+	return &dotProber{
+		addr:    addr,
+		timeout: timeout,
+		tls: &tls.Config{
+			ServerName:         serverName,
+			NextProtos:         []string{"dot"},
+			ClientSessionCache: tls.NewLRUClientSessionCache(1),
+		},
+		pending: make(map[uint16]chan []byte),
+	}
+}
+
+func (p *dotProber) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn == nil {
+		return nil
+	}
+	return p.closeLocked()
+}
+
+// Reconnect closes the connection, so the next query shows whether the resolver
+// resumes the TLS session
+func (p *dotProber) Reconnect() {
+	// The next query opens a new connection either way
+	_ = p.Close()
+}
+
+// Exchange sends one query and waits for its response until ctx is done. It is safe
+// for concurrent use; concurrent queries are pipelined on the same connection.
+func (p *dotProber) Exchange(ctx context.Context, name string, qtype dnsmessage.Type) (probeResponse, error) {
+	p.mu.Lock()
+	var handshake time.Duration
+	resumed := false
+	if p.conn == nil {
+		d := tls.Dialer{NetDialer: &net.Dialer{Timeout: p.timeout}, Config: p.tls}
+		start := time.Now()
+		conn, err := d.DialContext(ctx, "tcp", p.addr)
+		if err != nil {
+			p.mu.Unlock()
+			if ctx.Err() != nil {
+				return probeResponse{}, ctx.Err()
+			}
+			return probeResponse{}, err
+		}
+		handshake = time.Since(start)
+		tlsConn, ok := conn.(*tls.Conn)
+		if !ok {
+			p.mu.Unlock()
+			// The type mismatch is the error to report
+			_ = conn.Close()
+			return probeResponse{}, fmt.Errorf("unexpected connection type %T for %s", conn, p.addr)
+		}
+		p.conn = tlsConn
+		resumed = p.conn.ConnectionState().DidResume
+		go p.readResponses(p.conn)
+	}
+	conn := p.conn
+
+	// Pick an ID no query in flight uses
+	id := uint16(rand.Uint32())
+	for p.pending[id] != nil {
+		id++
+	}
+	reply := make(chan []byte, 1)
+	p.pending[id] = reply
+
+	question, query, err := buildProbeQuery(id, name, qtype)
+	if err != nil {
+		delete(p.pending, id)
+		p.mu.Unlock()
+		return probeResponse{}, err
+	}
+	msg := make([]byte, 2+len(query))
+	binary.BigEndian.PutUint16(msg, uint16(len(query)))
+	copy(msg[2:], query)
+
+	deadline, _ := ctx.Deadline()
+	start := time.Now()
+	err = conn.SetWriteDeadline(deadline)
+	if err == nil {
+		_, err = conn.Write(msg)
+	}
+	p.mu.Unlock()
+	if err != nil {
+		p.drop(conn)
+		return probeResponse{}, err
+	}
+
+	select {
+	case data, ok := <-reply:
+		if !ok {
+			return probeResponse{}, fmt.Errorf("connection closed before the response arrived")
+		}
+		response, err := parseProbeResponse(data, id, question)
+		if err != nil {
+			return probeResponse{}, err
+		}
+		response.Latency = time.Since(start)
+		response.Handshake = handshake
+		response.TLS = handshake > 0
+		response.Resumed = resumed
+		return response, nil
+	case <-ctx.Done():
+		p.mu.Lock()
+		if p.pending[id] == reply {
+			delete(p.pending, id)
+		}
+		p.mu.Unlock()
+		return probeResponse{}, ctx.Err()
+	}
+}
+
+// ExchangeAll sends a query for every name back to back and waits for all responses
+func (p *dotProber) ExchangeAll(ctx context.Context, names []string, qtype dnsmessage.Type) ([]probeResponse, []error) {
+	return exchangeConcurrently(ctx, p, names, qtype)
+}
+
+// readResponses hands the messages arriving on conn to the queries waiting for them,
+// until the connection fails or is closed
+func (p *dotProber) readResponses(conn *tls.Conn) {
+	for {
+		var length [2]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			break
+		}
+		data := make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(conn, data); err != nil {
+			break
+		}
+		if len(data) < 2 {
+			continue
+		}
+		id := binary.BigEndian.Uint16(data)
+		p.mu.Lock()
+		if reply, ok := p.pending[id]; ok {
+			delete(p.pending, id)
+			reply <- data
+		}
+		p.mu.Unlock()
+	}
+	p.drop(conn)
+}
+
+// drop closes conn after an error, unless it was already replaced
+func (p *dotProber) drop(conn *tls.Conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn != conn {
+		// Already failed or replaced, nothing to report
+		_ = conn.Close()
+		return
+	}
+	// The error that broke the connection is the one to report
+	_ = p.closeLocked()
+}
+
+// closeLocked closes the connection and fails the queries waiting on it
+func (p *dotProber) closeLocked() error {
+	err := p.conn.Close()
+	p.conn = nil
+	for id, reply := range p.pending {
+		close(reply)
+		delete(p.pending, id)
+	}
+	return err
+}
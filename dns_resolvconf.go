@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// resolvconfRecordSuffix is appended to the interface name to form our resolvconf record,
+// so it never collides with the records DHCP clients or VPNs add for the same interface
+const resolvconfRecordSuffix = ".alternatedns"
+
+// resolvconfBackend registers our nameservers as an extra record with resolvconf
+// (Debian's resolvconf or openresolv), which merges it with the records of other
+// contributors such as DHCP clients and VPNs when it generates /etc/resolv.conf.
+type resolvconfBackend struct {
+	mu     sync.Mutex
+	record string // record we added, empty if nothing has been applied yet
+}
+
+func (b *resolvconfBackend) Name() string {
+	return "resolvconf"
+}
+
+func (b *resolvconfBackend) Apply(servers []string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	addrs, err := parseServerList(servers)
+	if err != nil {
+		return err
+	}
+	if err := requireStandardPort(addrs, "resolvconf"); err != nil {
+		return err
+	}
+
+	record, err := resolvconfRecordName()
+	if err != nil {
+		return err
+	}
+	// Drop a record left on another interface, e.g. after switching from Ethernet to Wi-Fi
+	if b.record != "" && b.record != record {
+		if err := resolvconfDelete(b.record); err != nil {
+			appState.AddLog(fmt.Sprintf("Warning: Failed to remove resolvconf record %s: %v", b.record, err))
+		}
+	}
+
+	var sb strings.Builder
+	for _, addr := range addrs {
+		fmt.Fprintf(&sb, "nameserver %s\n", addr.IP)
+	}
+
+	args := []string{"-a", record}
+	if resolvconfIsOpenresolv() {
+		// Lowest metric wins, so our nameservers are listed before those from DHCP.
+		// Debian's resolvconf orders records through /etc/resolvconf/interface-order instead.
+		args = append(args, "-m", "0")
+	}
+	output, err := runSystemCommand(sb.String(), "resolvconf", args...)
+	if err != nil {
+		return fmt.Errorf("failed to add resolvconf record %s: %v. Output: %s", record, err, string(output))
+	}
+	b.record = record
+
+	if appState.GetDebugMode() {
+		appState.AddLog(fmt.Sprintf("Registered %s with resolvconf as %s", strings.Join(servers, ", "), record))
+	}
+	return nil
+}
+
+// MaxNameservers is the resolv.conf limit, since resolvconf generates that file
+func (b *resolvconfBackend) MaxNameservers() int {
+	return resolvConfMaxNameservers
+}
+
+func (b *resolvconfBackend) Restore() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	record := b.record
+	if record == "" {
+		// Nothing applied in this session, so no record of ours to remove
+		return nil
+	}
+	if err := resolvconfDelete(record); err != nil {
+		return err
+	}
+	b.record = ""
+	appState.AddLog(fmt.Sprintf("Removed resolvconf record %s", record))
+	return nil
+}
+
+// Current returns the nameservers of the generated resolv.conf, where our record is merged with the others
+func (b *resolvconfBackend) Current() ([]string, error) {
+	return readResolvConfNameservers()
+}
+
+func (b *resolvconfBackend) CurrentConfig() ([]interfaceDNS, error) {
+	return readResolvConfConfig()
+}
+
+func (b *resolvconfBackend) SaveJournal(j *dnsJournal) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	j.ResolvconfRecord = b.record
+}
+
+func (b *resolvconfBackend) LoadJournal(j *dnsJournal) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.record = j.ResolvconfRecord
+}
+
+// resolvconfRecordName returns the record name for the target interface
+func resolvconfRecordName() (string, error) {
+	iface, err := linuxTargetInterface()
+	if err != nil {
+		return "", err
+	}
+	return iface + resolvconfRecordSuffix, nil
+}
+
+// resolvconfDelete removes a record
+func resolvconfDelete(record string) error {
+	args := []string{"-d", record}
+	if resolvconfIsOpenresolv() {
+		// Don't fail if the record is already gone
+		args = append(args, "-f")
+	}
+	output, err := runSystemCommand("", "resolvconf", args...)
+	if err != nil {
+		return fmt.Errorf("failed to remove resolvconf record %s: %v. Output: %s", record, err, string(output))
+	}
+	return nil
+}
+
+// resolvconfIsOpenresolv reports whether the installed resolvconf is openresolv,
+// which understands metrics. Debian's resolvconf has no --version flag.
+func resolvconfIsOpenresolv() bool {
+	output, err := exec.Command("resolvconf", "--version").CombinedOutput()
+	return err == nil && strings.Contains(strings.ToLower(string(output)), "openresolv")
+}
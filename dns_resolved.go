@@ -0,0 +1,348 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	resolvedBusName   = "org.freedesktop.resolve1"
+	resolvedPath      = "/org/freedesktop/resolve1"
+	resolvedInterface = "org.freedesktop.resolve1.Manager"
+
+	// Address families as expected by systemd-resolved (Linux values, regardless of build OS)
+	resolvedAFInet  = 2
+	resolvedAFInet6 = 10
+)
+
+// resolvedLinkAddress mirrors the (iay) D-Bus structure used by SetLinkDNS
+type resolvedLinkAddress struct {
+	Family  int32
+	Address []byte
+}
+
+// resolvedLinkAddressEx mirrors the (iayqs) D-Bus structure used by SetLinkDNSEx,
+// which adds a port and a server name for TLS authentication
+type resolvedLinkAddressEx struct {
+	Family     int32
+	Address    []byte
+	Port       uint16
+	ServerName string
+}
+
+// resolvedManagerAddressEx mirrors the (iiayqs) entries of the Manager's DNSEx property;
+// Ifindex is 0 for global servers
+type resolvedManagerAddressEx struct {
+	Ifindex    int32
+	Family     int32
+	Address    []byte
+	Port       uint16
+	ServerName string
+}
+
+// resolvedManagerAddress mirrors the (iiay) entries of the Manager's DNS property
+type resolvedManagerAddress struct {
+	Ifindex int32
+	Family  int32
+	Address []byte
+}
+
+// resolvedLinkDomain mirrors the (sb) D-Bus structure used by SetLinkDomains
+type resolvedLinkDomain struct {
+	Domain      string
+	RoutingOnly bool
+}
+
+// resolvedBackend sets per-link DNS servers through systemd-resolved's D-Bus API.
+// This leaves /etc/resolv.conf (usually a symlink to the stub resolver) untouched,
+// so split DNS configured by VPN clients keeps working.
+type resolvedBackend struct {
+	mu      sync.Mutex
+	ifindex int  // link we changed, 0 if nothing has been applied yet
+	dot     bool // DNSOverTLS was switched on for the link
+}
+
+// resolvedAvailable reports whether systemd-resolved is running on the system bus
+func resolvedAvailable() bool {
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		return false
+	}
+	var hasOwner bool
+	err = conn.BusObject().Call("org.freedesktop.DBus.NameHasOwner", 0, resolvedBusName).Store(&hasOwner)
+	return err == nil && hasOwner
+}
+
+func (b *resolvedBackend) Name() string {
+	return "systemd-resolved"
+}
+
+func (b *resolvedBackend) Apply(servers []string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	iface, err := b.targetLink()
+	if err != nil {
+		return err
+	}
+
+	addrs, err := parseServerList(servers)
+	if err != nil {
+		return err
+	}
+
+	// DNSOverTLS is a link setting, a plain server on the same link would be asked over TLS too
+	dot := addrs[0].TLS
+	for _, addr := range addrs[1:] {
+		if addr.TLS != dot {
+			return fmt.Errorf("systemd-resolved cannot mix DNS-over-TLS and plain DNS servers on one link")
+		}
+	}
+
+	// The target interface changed since the last rotation, give the old link its own settings back
+	if b.ifindex != 0 && b.ifindex != iface.Index {
+		if err := resolvedCall("RevertLink", int32(b.ifindex)); err != nil {
+			appState.AddLog(fmt.Sprintf("Warning: Failed to revert DNS on link %d: %v", b.ifindex, err))
+		}
+		b.dot = false
+	}
+
+	if err := resolvedSetLinkDNS(iface.Index, addrs); err != nil {
+		return fmt.Errorf("failed to set DNS on %s via systemd-resolved: %v", iface.Name, err)
+	}
+	// An empty mode goes back to the global DNSOverTLS setting
+	if dot || b.dot {
+		mode := ""
+		if dot {
+			mode = "yes"
+		}
+		if err := resolvedCall("SetLinkDNSOverTLS", int32(iface.Index), mode); err != nil {
+			return fmt.Errorf("failed to set DNS-over-TLS on %s via systemd-resolved: %v", iface.Name, err)
+		}
+		b.dot = dot
+	}
+	// "~." makes this link the preferred route for every domain not claimed by another link
+	domains := []resolvedLinkDomain{{Domain: ".", RoutingOnly: true}}
+	if err := resolvedCall("SetLinkDomains", int32(iface.Index), domains); err != nil {
+		return fmt.Errorf("failed to set DNS domains on %s via systemd-resolved: %v", iface.Name, err)
+	}
+	b.ifindex = iface.Index
+
+	if appState.GetDebugMode() {
+		appState.AddLog(fmt.Sprintf("Set DNS on link %s (index %d) to %s via systemd-resolved", iface.Name, iface.Index, strings.Join(servers, ", ")))
+	}
+	return nil
+}
+
+func (b *resolvedBackend) Restore() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ifindex := b.ifindex
+	if ifindex == 0 {
+		// Nothing applied in this session, so no link of ours to revert
+		return nil
+	}
+	name := fmt.Sprintf("%d", ifindex)
+	if iface, err := net.InterfaceByIndex(ifindex); err == nil {
+		name = iface.Name
+	}
+
+	if err := resolvedCall("RevertLink", int32(ifindex)); err != nil {
+		return fmt.Errorf("failed to revert DNS on link %d via systemd-resolved: %v", ifindex, err)
+	}
+	b.ifindex = 0
+	b.dot = false
+	appState.AddLog(fmt.Sprintf("Reverted DNS settings on link %s via systemd-resolved", name))
+	return nil
+}
+
+// Current returns the DNS servers systemd-resolved has configured on the link
+func (b *resolvedBackend) Current() ([]string, error) {
+	b.mu.Lock()
+	ifindex := b.ifindex
+	b.mu.Unlock()
+	if ifindex == 0 {
+		iface, err := b.targetLink()
+		if err != nil {
+			return nil, err
+		}
+		ifindex = iface.Index
+	}
+	return resolvedLinkDNS(ifindex)
+}
+
+// CurrentConfig returns the global and per-link DNS servers known to systemd-resolved,
+// whether they come from us, DHCP or a VPN client
+func (b *resolvedBackend) CurrentConfig() ([]interfaceDNS, error) {
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to system bus: %v", err)
+	}
+	manager := conn.Object(resolvedBusName, dbus.ObjectPath(resolvedPath))
+
+	type linkServer struct {
+		ifindex int32
+		server  string
+	}
+	var found []linkServer
+	var addrsEx []resolvedManagerAddressEx
+	if err := manager.StoreProperty(resolvedInterface+".DNSEx", &addrsEx); err == nil {
+		for _, a := range addrsEx {
+			port := int(a.Port)
+			if port == 0 {
+				port = defaultDNSPort
+			}
+			found = append(found, linkServer{a.Ifindex, resolverAddr{IP: net.IP(a.Address), Port: port}.String()})
+		}
+	} else {
+		// systemd older than 246
+		var addrs []resolvedManagerAddress
+		if err := manager.StoreProperty(resolvedInterface+".DNS", &addrs); err != nil {
+			return nil, fmt.Errorf("failed to read DNS servers from systemd-resolved: %v", err)
+		}
+		for _, a := range addrs {
+			found = append(found, linkServer{a.Ifindex, net.IP(a.Address).String()})
+		}
+	}
+
+	// Group by link, keeping the order in which systemd-resolved lists them
+	var configs []interfaceDNS
+	position := make(map[int32]int)
+	for _, f := range found {
+		i, ok := position[f.ifindex]
+		if !ok {
+			name := "global"
+			if f.ifindex != 0 {
+				name = fmt.Sprintf("link %d", f.ifindex)
+				if iface, err := net.InterfaceByIndex(int(f.ifindex)); err == nil {
+					name = iface.Name
+				}
+			}
+			i = len(configs)
+			position[f.ifindex] = i
+			configs = append(configs, interfaceDNS{Interface: name, Source: "systemd-resolved"})
+		}
+		configs[i].Servers = append(configs[i].Servers, f.server)
+	}
+	return configs, nil
+}
+
+// SupportsDNSOverTLS reports true: servers are applied with SetLinkDNSEx and SetLinkDNSOverTLS
+func (b *resolvedBackend) SupportsDNSOverTLS() bool {
+	return true
+}
+
+func (b *resolvedBackend) SaveJournal(j *dnsJournal) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	j.ResolvedIfindex = b.ifindex
+}
+
+func (b *resolvedBackend) LoadJournal(j *dnsJournal) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.ifindex = j.ResolvedIfindex
+}
+
+// targetLink returns the network link DNS settings should be attached to
+func (b *resolvedBackend) targetLink() (*net.Interface, error) {
+	name, err := linuxTargetInterface()
+	if err != nil {
+		return nil, err
+	}
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up interface %s: %v", name, err)
+	}
+	return iface, nil
+}
+
+// resolvedSetLinkDNS sets the DNS servers of a link. SetLinkDNSEx (systemd 246+) is only
+// used when a server needs a non-standard port or a TLS server name, so older versions
+// keep working otherwise.
+func resolvedSetLinkDNS(ifindex int, addrs []resolverAddr) error {
+	needsEx := false
+	for _, addr := range addrs {
+		if addr.Port != defaultDNSPort || addr.ServerName != "" {
+			needsEx = true
+		}
+	}
+
+	if !needsEx {
+		addresses := make([]resolvedLinkAddress, 0, len(addrs))
+		for _, addr := range addrs {
+			family, ip := resolvedFamily(addr.IP)
+			addresses = append(addresses, resolvedLinkAddress{Family: family, Address: ip})
+		}
+		return resolvedCall("SetLinkDNS", int32(ifindex), addresses)
+	}
+
+	addresses := make([]resolvedLinkAddressEx, 0, len(addrs))
+	for _, addr := range addrs {
+		family, ip := resolvedFamily(addr.IP)
+		addresses = append(addresses, resolvedLinkAddressEx{Family: family, Address: ip, Port: uint16(addr.Port), ServerName: addr.ServerName})
+	}
+	return resolvedCall("SetLinkDNSEx", int32(ifindex), addresses)
+}
+
+// resolvedLinkDNS reads the DNS servers configured on a link
+func resolvedLinkDNS(ifindex int) ([]string, error) {
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to system bus: %v", err)
+	}
+	var linkPath dbus.ObjectPath
+	err = conn.Object(resolvedBusName, dbus.ObjectPath(resolvedPath)).
+		Call(resolvedInterface+".GetLink", 0, int32(ifindex)).Store(&linkPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up link %d in systemd-resolved: %v", ifindex, err)
+	}
+	link := conn.Object(resolvedBusName, linkPath)
+
+	var servers []string
+	// DNSEx includes the port and TLS server name, fall back to DNS on systemd older than 246
+	var addrsEx []resolvedLinkAddressEx
+	if err := link.StoreProperty("org.freedesktop.resolve1.Link.DNSEx", &addrsEx); err == nil {
+		var mode string
+		if err := link.StoreProperty("org.freedesktop.resolve1.Link.DNSOverTLS", &mode); err != nil {
+			return nil, fmt.Errorf("failed to read DNS-over-TLS mode of link %d: %v", ifindex, err)
+		}
+		dot := mode == "yes"
+		for _, a := range addrsEx {
+			addr := resolverAddr{IP: net.IP(a.Address), Port: int(a.Port), TLS: dot, ServerName: a.ServerName}
+			if addr.Port == 0 && dot {
+				addr.Port = defaultDoTPort
+			} else if addr.Port == 0 {
+				addr.Port = defaultDNSPort
+			}
+			servers = append(servers, addr.String())
+		}
+		return servers, nil
+	}
+	var addrs []resolvedLinkAddress
+	if err := link.StoreProperty("org.freedesktop.resolve1.Link.DNS", &addrs); err != nil {
+		return nil, fmt.Errorf("failed to read DNS of link %d: %v", ifindex, err)
+	}
+	for _, a := range addrs {
+		servers = append(servers, net.IP(a.Address).String())
+	}
+	return servers, nil
+}
+
+// resolvedFamily returns the address family and raw bytes of ip as systemd-resolved expects them
+func resolvedFamily(ip net.IP) (int32, []byte) {
+	if ip4 := ip.To4(); ip4 != nil {
+		return resolvedAFInet, ip4
+	}
+	return resolvedAFInet6, ip.To16()
+}
+
+// resolvedCall invokes a method on the systemd-resolved manager object
+func resolvedCall(method string, args ...interface{}) error {
+	return callSystemDBus(resolvedBusName, resolvedPath, resolvedInterface+"."+method, args...)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	resolvConfPath         = "/etc/resolv.conf"
+	resolvConfSnapshotFile = "resolv_snapshot.yaml"
+)
+
+// resolvConfSnapshot records the exact state of /etc/resolv.conf before we first touched it
+type resolvConfSnapshot struct {
+	Path       string    `yaml:"path"`
+	Exists     bool      `yaml:"exists"`
+	IsSymlink  bool      `yaml:"is_symlink"`
+	LinkTarget string    `yaml:"link_target,omitempty"`
+	Contents   string    `yaml:"contents,omitempty"` // base64, so the file comes back byte-for-byte
+	Mode       uint32    `yaml:"mode,omitempty"`
+	HasOwner   bool      `yaml:"has_owner"`
+	UID        int       `yaml:"uid"`
+	GID        int       `yaml:"gid"`
+	CapturedAt time.Time `yaml:"captured_at"`
+}
+
+// captureResolvConf reads the current state of the resolv.conf at path
+func captureResolvConf(path string) (*resolvConfSnapshot, error) {
+	snapshot := &resolvConfSnapshot{
+		Path:       path,
+		CapturedAt: time.Now(),
+	}
+
+	linfo, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return snapshot, nil
+	} else if err != nil {
+		return nil, err
+	}
+	snapshot.Exists = true
+
+	if linfo.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		if err != nil {
+			return nil, err
+		}
+		snapshot.IsSymlink = true
+		snapshot.LinkTarget = target
+		return snapshot, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	snapshot.Contents = base64.StdEncoding.EncodeToString(data)
+	snapshot.Mode = uint32(linfo.Mode().Perm())
+	snapshot.UID, snapshot.GID, snapshot.HasOwner = fileOwner(linfo)
+	return snapshot, nil
+}
+
+// ensureResolvConfSnapshot saves the original resolv.conf next to the config,
+// unless a snapshot from an earlier change is still waiting to be restored
+func ensureResolvConfSnapshot() error {
+	snapshotPath, err := getDataPath(resolvConfSnapshotFile)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(snapshotPath); err == nil {
+		return nil
+	}
+
+	snapshot, err := captureResolvConf(resolvConfPath)
+	if err != nil {
+		return fmt.Errorf("failed to capture %s: %v", resolvConfPath, err)
+	}
+	data, err := yaml.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	if appState.GetDryRun() {
+		dryRunLog(fmt.Sprintf("would save original %s to %s", resolvConfPath, snapshotPath))
+		return nil
+	}
+	if err := os.WriteFile(snapshotPath, data, 0600); err != nil {
+		return fmt.Errorf("failed to save resolver snapshot: %v", err)
+	}
+	if appState.GetDebugMode() {
+		appState.AddLog(fmt.Sprintf("Saved original %s to %s", resolvConfPath, snapshotPath))
+	}
+	return nil
+}
+
+// loadResolvConfSnapshot returns the saved snapshot, or nil if there is none
+func loadResolvConfSnapshot() (*resolvConfSnapshot, error) {
+	snapshotPath, err := getDataPath(resolvConfSnapshotFile)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(snapshotPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var snapshot resolvConfSnapshot
+	if err := yaml.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("failed to parse resolver snapshot: %v", err)
+	}
+	return &snapshot, nil
+}
+
+// restoreResolvConfSnapshot puts resolv.conf back the way it was before our
+// first change and removes the snapshot afterwards
+func restoreResolvConfSnapshot() error {
+	snapshot, err := loadResolvConfSnapshot()
+	if err != nil {
+		return err
+	}
+	if snapshot == nil {
+		return nil
+	}
+
+	current, err := captureResolvConf(snapshot.Path)
+	if err != nil {
+		return err
+	}
+	if snapshot.matches(current) {
+		appState.AddLog(fmt.Sprintf("%s already matches the original, nothing to restore", snapshot.Path))
+	} else {
+		if err := snapshot.write(); err != nil {
+			return fmt.Errorf("failed to restore %s: %v", snapshot.Path, err)
+		}
+		appState.AddLog(fmt.Sprintf("Restored original %s", snapshot.Path))
+	}
+
+	snapshotPath, err := getDataPath(resolvConfSnapshotFile)
+	if err != nil {
+		return err
+	}
+	if err := removeSystemFile(snapshotPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove resolver snapshot: %v", err)
+	}
+	return nil
+}
+
+// matches reports whether other describes the same file state
+func (s *resolvConfSnapshot) matches(other *resolvConfSnapshot) bool {
+	if s.Exists != other.Exists || s.IsSymlink != other.IsSymlink {
+		return false
+	}
+	if s.IsSymlink {
+		return s.LinkTarget == other.LinkTarget
+	}
+	return s.Contents == other.Contents && s.Mode == other.Mode &&
+		(!s.HasOwner || (s.UID == other.UID && s.GID == other.GID))
+}
+
+// write recreates the snapshotted file state on disk
+func (s *resolvConfSnapshot) write() error {
+	if !s.Exists {
+		if _, err := os.Lstat(s.Path); os.IsNotExist(err) {
+			return nil
+		}
+		err := removeSystemFile(s.Path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	if s.IsSymlink {
+		return symlinkSystemFile(s.LinkTarget, s.Path)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(s.Contents)
+	if err != nil {
+		return fmt.Errorf("corrupt snapshot contents: %v", err)
+	}
+	uid, gid := -1, -1
+	if s.HasOwner {
+		uid, gid = s.UID, s.GID
+	}
+	return writeSystemFile(s.Path, data, os.FileMode(s.Mode), uid, gid)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// stampScheme prefixes DNS stamps as published in the public-resolvers list,
+// e.g. "sdns://AQcAAAAAAAAAB...". See https://dnscrypt.info/stamps-specifications.
+const stampScheme = "sdns://"
+
+// stampProtoDNSCrypt is the protocol identifier of DNSCrypt stamps
+const stampProtoDNSCrypt = 0x01
+
+// defaultDNSCryptPort is the port DNSCrypt resolvers listen on unless the stamp says otherwise
+const defaultDNSCryptPort = 443
+
+// dnscryptStamp holds what a DNSCrypt stamp says about a resolver besides its address
+type dnscryptStamp struct {
+	Raw          string            // the stamp as listed, sdns:// included
+	Props        uint64            // informal properties: DNSSEC, no logs, no filter
+	ProviderName string            // e.g. "2.dnscrypt-cert.example.com", the name certificates are published under
+	PublicKey    ed25519.PublicKey // key the provider signs its certificates with
+}
+
+// parseStamp decodes an sdns:// stamp into the address of the resolver. Only
+// DNSCrypt stamps are supported.
+func parseStamp(s string) (resolverAddr, error) {
+	rest, _ := cutPrefixFold(s, stampScheme)
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(rest, "="))
+	if err != nil {
+		return resolverAddr{}, fmt.Errorf("invalid DNS stamp %q: %v", s, err)
+	}
+	if len(data) == 0 || data[0] != stampProtoDNSCrypt {
+		proto := -1
+		if len(data) > 0 {
+			proto = int(data[0])
+		}
+		return resolverAddr{}, fmt.Errorf("unsupported DNS stamp %q (protocol %d), only DNSCrypt stamps are supported", s, proto)
+	}
+	data = data[1:]
+
+	if len(data) < 8 {
+		return resolverAddr{}, fmt.Errorf("invalid DNS stamp %q: too short", s)
+	}
+	stamp := &dnscryptStamp{Raw: s, Props: binary.LittleEndian.Uint64(data)}
+	data = data[8:]
+
+	var addr, key, providerName []byte
+	for _, field := range []*[]byte{&addr, &key, &providerName} {
+		if len(data) == 0 || len(data) < 1+int(data[0]) {
+			return resolverAddr{}, fmt.Errorf("invalid DNS stamp %q: too short", s)
+		}
+		*field = data[1 : 1+int(data[0])]
+		data = data[1+int(data[0]):]
+	}
+	if len(data) != 0 {
+		return resolverAddr{}, fmt.Errorf("invalid DNS stamp %q: %d unexpected trailing bytes", s, len(data))
+	}
+	if len(key) != ed25519.PublicKeySize {
+		return resolverAddr{}, fmt.Errorf("invalid DNS stamp %q: public key is %d bytes, not %d", s, len(key), ed25519.PublicKeySize)
+	}
+	if len(providerName) == 0 {
+		return resolverAddr{}, fmt.Errorf("invalid DNS stamp %q: missing provider name", s)
+	}
+	stamp.PublicKey = ed25519.PublicKey(key)
+	stamp.ProviderName = string(providerName)
+
+	a, err := parseHostPort(string(addr), defaultDNSCryptPort)
+	if err != nil {
+		// Stamps may list only a host name, which we would have to look up over plain DNS
+		return resolverAddr{}, fmt.Errorf("invalid DNS stamp %q: %v", s, err)
+	}
+	a.DNSCrypt = stamp
+	return a, nil
+}
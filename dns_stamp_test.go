@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+// buildDNSCryptStamp encodes a DNSCrypt stamp from its fields
+func buildDNSCryptStamp(props uint64, addr string, key []byte, providerName string) string {
+	data := []byte{stampProtoDNSCrypt}
+	data = binary.LittleEndian.AppendUint64(data, props)
+	for _, field := range [][]byte{[]byte(addr), key, []byte(providerName)} {
+		data = append(data, byte(len(field)))
+		data = append(data, field...)
+	}
+	return stampScheme + base64.RawURLEncoding.EncodeToString(data)
+}
+
+func TestParseStamp(t *testing.T) {
+	key := bytes.Repeat([]byte{0xab}, ed25519.PublicKeySize)
+	tests := []struct {
+		name  string
+		stamp string
+		ip    string
+		port  int
+	}{
+		{"IPv4", buildDNSCryptStamp(7, "198.51.100.1", key, "2.dnscrypt-cert.example.com"), "198.51.100.1", 443},
+		{"IPv4 with port", buildDNSCryptStamp(0, "198.51.100.1:5443", key, "2.dnscrypt-cert.example.com"), "198.51.100.1", 5443},
+		{"IPv6", buildDNSCryptStamp(1, "[2001:db8::53]", key, "2.dnscrypt-cert.example.com"), "2001:db8::53", 443},
+		{"IPv6 with port", buildDNSCryptStamp(1, "[2001:db8::53]:8443", key, "2.dnscrypt-cert.example.com"), "2001:db8::53", 8443},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry, err := parseResolverEntry(tt.stamp)
+			if err != nil {
+				t.Fatalf("parseResolverEntry: %v", err)
+			}
+			if len(entry.Addrs) != 1 {
+				t.Fatalf("got %d addresses, want 1", len(entry.Addrs))
+			}
+			addr := entry.Addrs[0]
+			if !addr.IP.Equal(net.ParseIP(tt.ip)) || addr.Port != tt.port {
+				t.Errorf("address = %s, want %s port %d", addr.HostPort(), tt.ip, tt.port)
+			}
+			if addr.DNSCrypt == nil || addr.DNSCrypt.ProviderName != "2.dnscrypt-cert.example.com" || !bytes.Equal(addr.DNSCrypt.PublicKey, key) {
+				t.Fatalf("stamp = %+v", addr.DNSCrypt)
+			}
+			if addr.String() != tt.stamp {
+				t.Errorf("String() = %q, want the stamp", addr.String())
+			}
+			if !addr.testOnly() {
+				t.Error("a DNSCrypt address can be applied")
+			}
+		})
+	}
+}
+
+func TestParseStampInvalid(t *testing.T) {
+	key := bytes.Repeat([]byte{0xab}, ed25519.PublicKeySize)
+	valid := buildDNSCryptStamp(0, "198.51.100.1", key, "2.dnscrypt-cert.example.com")
+	data, err := base64.RawURLEncoding.DecodeString(valid[len(stampScheme):])
+	if err != nil {
+		t.Fatal(err)
+	}
+	encode := func(data []byte) string {
+		return stampScheme + base64.RawURLEncoding.EncodeToString(data)
+	}
+	doh := append([]byte{0x02}, data[1:]...)
+
+	tests := map[string]string{
+		"empty":            "sdns://",
+		"not base64":       "sdns://not*base64",
+		"DoH stamp":        encode(doh),
+		"truncated props":  encode(data[:5]),
+		"truncated key":    encode(data[:30]),
+		"trailing bytes":   encode(append(append([]byte(nil), data...), 0)),
+		"short key":        buildDNSCryptStamp(0, "198.51.100.1", key[:31], "2.dnscrypt-cert.example.com"),
+		"no provider name": buildDNSCryptStamp(0, "198.51.100.1", key, ""),
+		"host name":        buildDNSCryptStamp(0, "dnscrypt.example.com:443", key, "2.dnscrypt-cert.example.com"),
+		"bad port":         buildDNSCryptStamp(0, "198.51.100.1:0", key, "2.dnscrypt-cert.example.com"),
+	}
+	for name, stamp := range tests {
+		if _, err := parseResolverEntry(stamp); err == nil {
+			t.Errorf("%s: parseResolverEntry(%q) succeeded", name, stamp)
+		}
+	}
+}
@@ -3,9 +3,20 @@ package main
 import (
 	"context"
 	"fmt"
-	"net"
+	"math/rand"
+	"net/http"
 	"strings"
+	"sync"
 	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// defaultTestConcurrency is how many resolver addresses are tested at the same time
+	defaultTestConcurrency = 8
+	// defaultTestDeadline bounds a whole benchmark run
+	defaultTestDeadline = 30 * time.Second
 )
 
 // DNSTestResult represents the result of testing a DNS server
@@ -17,6 +28,31 @@ type DNSTestResult struct {
 	Error        string
 	TestCount    int
 	SuccessCount int
+	Addresses    []AddressResult // per address results, so IPv4 and IPv6 are reported separately
+	Baseline     bool            // a resolver the system was already using, tested for comparison
+	Stats        latencyStats    // distribution of warm (cached) query latencies
+	Cold         latencyStats    // distribution of cold (uncached) query latencies
+	Responses    responseSummary // rcodes, flags, TTLs and sizes of all measured answers
+	Handshake    latencyStats    // TCP (and TLS for DoH and DoT) connection setup times, apart from query latency
+}
+
+// AddressResult holds the results for one address of a DNS entry
+type AddressResult struct {
+	Address      string
+	Family       string // "IPv4" or "IPv6", "DoT IPv4" or "DoT IPv6", or how a DoH endpoint was queried, e.g. "DoH GET"
+	AvgLatency   time.Duration
+	TestCount    int
+	SuccessCount int
+	Error        string
+	Stats        latencyStats // warm
+	Cold         latencyStats
+	Responses    responseSummary // rcodes, flags, TTLs and sizes of the measured answers
+	Handshake    latencyStats
+}
+
+// Working reports whether the address resolved at least one domain
+func (r AddressResult) Working() bool {
+	return r.SuccessCount > 0
 }
 
 // Default test domains for benchmarking
@@ -28,59 +64,237 @@ var defaultTestDomains = []string{
 	"amazon.com",
 }
 
-// testDNSLatency tests a DNS server by resolving multiple domains and returns average latency
-func testDNSLatency(dnsServer string, testDomains []string, timeout time.Duration) DNSTestResult {
-	result := DNSTestResult{
-		DNS:          dnsServer,
-		Status:       "success",
-		TestCount:    len(testDomains),
-		SuccessCount: 0,
-	}
+// benchmarkConfig controls a benchmark run
+type benchmarkConfig struct {
+	Domains   []string        // names measured warm, after priming the cache
+	ColdZones []string        // zones random names are generated under
+	QueryType dnsmessage.Type // record type of every query
+	Transport string          // test_transport of plain DNS entries
+	Rounds    int             // queries per domain and per cold zone
+	Timeout   time.Duration   // per query
+	Workers   int             // addresses tested at the same time
+	Deadline  time.Duration   // for the whole run
+}
 
+// newBenchmarkConfig returns the benchmark settings from the config
+func newBenchmarkConfig(testDomains []string, timeout time.Duration) benchmarkConfig {
 	if len(testDomains) == 0 {
 		testDomains = defaultTestDomains
-		result.TestCount = len(testDomains)
 	}
+	workers := config.TestConcurrency
+	if workers <= 0 {
+		workers = defaultTestConcurrency
+	}
+	deadline := time.Duration(config.TestDeadlineSeconds) * time.Second
+	if deadline <= 0 {
+		deadline = defaultTestDeadline
+	}
+	rounds := config.TestRounds
+	if rounds <= 0 {
+		rounds = defaultTestRounds
+	}
+	coldZones := config.ColdTestZones
+	if len(coldZones) == 0 {
+		// Unsigned zones work too: without DNSSEC the resolver can't synthesise
+		// NXDOMAIN from cached NSEC records and has to ask upstream
+		coldZones = testDomains
+	}
+	return benchmarkConfig{
+		Domains:   testDomains,
+		ColdZones: coldZones,
+		QueryType: probeQueryType(),
+		Transport: testTransport(),
+		Rounds:    rounds,
+		Timeout:   timeout,
+		Workers:   workers,
+		Deadline:  deadline,
+	}
+}
 
-	var latencies []time.Duration
-	var errors []string
+// probeTarget is what one benchmark job measures: an address of a plain DNS
+// entry, or one way of querying an encrypted endpoint
+type probeTarget struct {
+	Address string
+	Family  string
+	open    func(ctx context.Context, timeout time.Duration) (dnsProber, error)
+}
 
-	for _, domain := range testDomains {
-		start := time.Now()
-		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+// probeTargets returns the targets of an entry: every address, over the test
+// transport, TLS, QUIC or DNSCrypt, or a DoH endpoint with GET and with POST
+func probeTargets(entry resolverEntry, transport string) []probeTarget {
+	if entry.DoH != nil {
+		endpoint := entry.DoH
+		var targets []probeTarget
+		for _, method := range []string{http.MethodGet, http.MethodPost} {
+			targets = append(targets, probeTarget{
+				Address: endpoint.String(),
+				Family:  "DoH " + method,
+				open: func(ctx context.Context, timeout time.Duration) (dnsProber, error) {
+					return newDoHProber(endpoint, method, timeout), nil
+				},
+			})
+		}
+		return targets
+	}
 
-		// Create custom resolver for this DNS server
-		resolver := &net.Resolver{
-			PreferGo: true,
-			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
-				d := net.Dialer{
-					Timeout: timeout,
-				}
-				return d.DialContext(ctx, "udp", dnsServer+":53")
-			},
+	targets := make([]probeTarget, 0, len(entry.Addrs))
+	for _, addr := range entry.Addrs {
+		hostPort := addr.HostPort()
+		if addr.TLS {
+			targets = append(targets, probeTarget{
+				Address: addr.String(),
+				Family:  "DoT " + ipFamily(addr.IP),
+				open: func(ctx context.Context, timeout time.Duration) (dnsProber, error) {
+					return newDoTProber(hostPort, addr.ServerName, addr.IP, timeout), nil
+				},
+			})
+			continue
 		}
+		if addr.DNSCrypt != nil {
+			stamp := addr.DNSCrypt
+			targets = append(targets, probeTarget{
+				Address: addr.String(),
+				Family:  "DNSCrypt " + ipFamily(addr.IP),
+				open: func(ctx context.Context, timeout time.Duration) (dnsProber, error) {
+					return newDNSCryptProber(ctx, hostPort, stamp, timeout)
+				},
+			})
+			continue
+		}
+		if addr.QUIC {
+			targets = append(targets, probeTarget{
+				Address: addr.String(),
+				Family:  "DoQ " + ipFamily(addr.IP),
+				open: func(ctx context.Context, timeout time.Duration) (dnsProber, error) {
+					return newDoQProber(hostPort, addr.ServerName, addr.IP, timeout), nil
+				},
+			})
+			continue
+		}
+		targets = append(targets, probeTarget{
+			Address: addr.String(),
+			Family:  ipFamily(addr.IP),
+			open: func(ctx context.Context, timeout time.Duration) (dnsProber, error) {
+				return newProber(ctx, hostPort, transport, timeout)
+			},
+		})
+	}
+	return targets
+}
 
-		// Test DNS resolution
-		_, err := resolver.LookupIPAddr(ctx, domain)
-		latency := time.Since(start)
-		cancel()
+// addressOutcome is what testing one address of an entry produced
+type addressOutcome struct {
+	result        AddressResult
+	latencies     []time.Duration // warm
+	coldLatencies []time.Duration
+	handshakes    []time.Duration
+	errors        []string
+}
 
+// benchmarkDNS tests DNS entries with a bounded pool of workers, one probe target per job,
+// and stops everything when ctx is cancelled or the deadline passes. progress is called
+// (never concurrently) with the config index of every entry as soon as it is complete.
+// Results are returned in the order of entries.
+func benchmarkDNS(ctx context.Context, entries []string, cfg benchmarkConfig, progress func(index int, result DNSTestResult)) []DNSTestResult {
+	ctx, cancel := context.WithTimeout(ctx, cfg.Deadline)
+	defer cancel()
+
+	type job struct {
+		entry  int
+		target probeTarget
+		slot   int
+	}
+
+	var mu sync.Mutex
+	results := make([]DNSTestResult, len(entries))
+	outcomes := make([][]addressOutcome, len(entries))
+	pending := make([]int, len(entries))
+	complete := func(i int) {
+		results[i] = summarizeEntry(entries[i], outcomes[i])
+		if progress != nil {
+			progress(i, results[i])
+		}
+	}
+
+	var jobs []job
+	for i, dns := range entries {
+		entry, err := parseResolverEntry(dns)
 		if err != nil {
-			errors = append(errors, fmt.Sprintf("%s: %v", domain, err))
-			result.Status = "partial"
-		} else {
-			latencies = append(latencies, latency)
-			result.SuccessCount++
+			results[i] = DNSTestResult{DNS: dns, Status: "error", Error: err.Error()}
+			if progress != nil {
+				progress(i, results[i])
+			}
+			continue
 		}
+		targets := probeTargets(entry, cfg.Transport)
+		outcomes[i] = make([]addressOutcome, len(targets))
+		pending[i] = len(targets)
+		for slot, target := range targets {
+			jobs = append(jobs, job{entry: i, target: target, slot: slot})
+		}
+	}
+
+	jobCh := make(chan job)
+	var wg sync.WaitGroup
+	for w := 0; w < min(cfg.Workers, len(jobs)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobCh {
+				outcome := testAddressLatency(ctx, j.target, cfg)
+				mu.Lock()
+				outcomes[j.entry][j.slot] = outcome
+				pending[j.entry]--
+				if pending[j.entry] == 0 {
+					complete(j.entry)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	// Jobs queued after a cancellation return at once with the context error
+	for _, j := range jobs {
+		jobCh <- j
+	}
+	close(jobCh)
+	wg.Wait()
+	return results
+}
+
+// summarizeEntry combines the results of the addresses of an entry
+func summarizeEntry(dnsServer string, outcomes []addressOutcome) DNSTestResult {
+	result := DNSTestResult{
+		DNS:    dnsServer,
+		Status: "success",
+	}
+
+	var latencies, coldLatencies, handshakes []time.Duration
+	var errors []string
+	warmSent, coldSent := 0, 0
+	for _, outcome := range outcomes {
+		result.Addresses = append(result.Addresses, outcome.result)
+		result.TestCount += outcome.result.TestCount
+		result.SuccessCount += outcome.result.SuccessCount
+		latencies = append(latencies, outcome.latencies...)
+		coldLatencies = append(coldLatencies, outcome.coldLatencies...)
+		handshakes = append(handshakes, outcome.handshakes...)
+		warmSent += outcome.result.Stats.Sent
+		coldSent += outcome.result.Cold.Sent
+		result.Responses.merge(outcome.result.Responses)
+		errors = append(errors, outcome.errors...)
+	}
+
+	if len(errors) > 0 {
+		result.Status = "partial"
 	}
 
+	result.Stats = computeLatencyStats(latencies, warmSent)
+	result.Cold = computeLatencyStats(coldLatencies, coldSent)
+	result.Handshake = computeLatencyStats(handshakes, len(handshakes))
+
 	// Calculate average latency
 	if len(latencies) > 0 {
-		var total time.Duration
-		for _, lat := range latencies {
-			total += lat
-		}
-		result.AvgLatency = total / time.Duration(len(latencies))
+		result.AvgLatency = averageLatency(latencies)
 		result.SuccessRate = float64(result.SuccessCount) / float64(result.TestCount) * 100
 	} else {
 		result.Status = "error"
@@ -95,87 +309,230 @@ func testDNSLatency(dnsServer string, testDomains []string, timeout time.Duratio
 	return result
 }
 
-// findBestDNS tests all DNS servers and returns the one with the lowest latency
-// Returns: (bestDNS, bestIndex)
-func findBestDNS(dnsServers []string, testDomains []string) (string, int) {
-	if len(dnsServers) == 0 {
-		return "", -1
+// testAddressLatency measures a single resolver address or DoH method. After one unmeasured query per
+// test domain to prime its cache, cfg.Rounds rounds of the same names measure cached
+// (warm) answers, and cfg.Rounds random names under each cold zone measure answers the
+// resolver has to fetch upstream. Queries are sent on the wire with the record type from
+// test_query_type, bypassing the hosts file, over the test_transport, TLS or HTTPS; sockets
+// and connections are reused for all of them. Over DoT the priming queries are pipelined,
+// and over TLS the connection is reopened after priming to see if the session resumes.
+func testAddressLatency(ctx context.Context, target probeTarget, cfg benchmarkConfig) addressOutcome {
+	result := AddressResult{
+		Address:   target.Address,
+		Family:    target.Family,
+		TestCount: (len(cfg.Domains) + len(cfg.ColdZones)) * cfg.Rounds,
 	}
+	timeout := cfg.Timeout
 
-	if len(testDomains) == 0 {
-		testDomains = defaultTestDomains
+	prober, err := target.open(ctx, timeout)
+	if err != nil {
+		result.Error = err.Error()
+		result.Stats = computeLatencyStats(nil, len(cfg.Domains)*cfg.Rounds)
+		result.Cold = computeLatencyStats(nil, len(cfg.ColdZones)*cfg.Rounds)
+		return addressOutcome{result: result, errors: []string{fmt.Sprintf("%s: %v", result.Family, err)}}
 	}
+	defer prober.Close()
 
-	timeout := 3 * time.Second
-	appState.AddLog(fmt.Sprintf("Testing all %d DNS servers to find the best one...", len(dnsServers)))
+	var errors []string
+	var handshakes []time.Duration
+	// exchange sends one query, noting the handshake if it opened a connection
+	exchange := func(ctx context.Context, name string) (probeResponse, error) {
+		response, err := prober.Exchange(ctx, name, cfg.QueryType)
+		if err == nil && response.Handshake > 0 {
+			handshakes = append(handshakes, response.Handshake)
+		}
+		return response, err
+	}
+	// lookup queries name and returns its latency, or false if there was no usable answer.
+	// For random names NXDOMAIN is the expected answer and counts as one.
+	lookup := func(name string, nxdomainOK bool) (time.Duration, bool) {
+		if err := ctx.Err(); err != nil {
+			errors = append(errors, fmt.Sprintf("%s via %s: %v", name, result.Family, err))
+			return 0, false
+		}
+		lookupCtx, cancel := context.WithTimeout(ctx, timeout)
+		response, err := exchange(lookupCtx, name)
+		cancel()
+		if err != nil {
+			errors = append(errors, fmt.Sprintf("%s via %s: %v", name, result.Family, err))
+			return 0, false
+		}
+		result.Responses.add(response)
+		if response.RCode != dnsmessage.RCodeSuccess && !(nxdomainOK && response.RCode == dnsmessage.RCodeNameError) {
+			errors = append(errors, fmt.Sprintf("%s via %s: %s", name, result.Family, rcodeName(response.RCode)))
+			return 0, false
+		}
+		return response.Latency, true
+	}
 
-	var bestDNS string
-	bestIdx := -1
-	bestLatency := time.Duration(0)
-	bestSuccessRate := 0.0
+	// Errors while priming show up again in the measured rounds
+	if pipeliner, ok := prober.(pipeliningProber); ok && ctx.Err() == nil {
+		primeCtx, cancel := context.WithTimeout(ctx, timeout)
+		responses, errs := pipeliner.ExchangeAll(primeCtx, cfg.Domains, cfg.QueryType)
+		cancel()
+		for i, response := range responses {
+			if errs[i] == nil && response.Handshake > 0 {
+				handshakes = append(handshakes, response.Handshake)
+			}
+		}
+	} else {
+		for _, domain := range cfg.Domains {
+			if ctx.Err() != nil {
+				break
+			}
+			primeCtx, cancel := context.WithTimeout(ctx, timeout)
+			// Errors while priming show up again in the measured rounds
+			_, _ = exchange(primeCtx, domain)
+			cancel()
+		}
+	}
+	if resumer, ok := prober.(sessionResumer); ok {
+		resumer.Reconnect()
+	}
 
-	for idx, dns := range dnsServers {
-		result := testDNSLatency(dns, testDomains, timeout)
+	var latencies, coldLatencies []time.Duration
+	for round := 0; round < cfg.Rounds; round++ {
+		for _, domain := range cfg.Domains {
+			if latency, ok := lookup(domain, false); ok {
+				latencies = append(latencies, latency)
+				result.SuccessCount++
+			}
+		}
+		for _, zone := range cfg.ColdZones {
+			if latency, ok := lookup(randomColdName(zone), true); ok {
+				coldLatencies = append(coldLatencies, latency)
+				result.SuccessCount++
+			}
+		}
+	}
 
+	result.Stats = computeLatencyStats(latencies, len(cfg.Domains)*cfg.Rounds)
+	result.Cold = computeLatencyStats(coldLatencies, len(cfg.ColdZones)*cfg.Rounds)
+	result.Handshake = computeLatencyStats(handshakes, len(handshakes))
+	if len(latencies) > 0 {
+		result.AvgLatency = averageLatency(latencies)
+	} else if len(errors) > 0 {
+		result.Error = errors[0]
+	}
+	return addressOutcome{result: result, latencies: latencies, coldLatencies: coldLatencies, handshakes: handshakes, errors: errors}
+}
+
+// randomColdName returns a fully qualified name under zone that no resolver can have cached
+func randomColdName(zone string) string {
+	return fmt.Sprintf("adns-%016x.%s.", rand.Uint64(), strings.Trim(zone, "."))
+}
+
+func averageLatency(latencies []time.Duration) time.Duration {
+	var total time.Duration
+	for _, lat := range latencies {
+		total += lat
+	}
+	return total / time.Duration(len(latencies))
+}
+
+// findBestDNS tests all DNS servers and ranks the working ones, best first
+// Returns: indexes into dnsServers (empty if every server failed) and the test result of every server
+func findBestDNS(dnsServers []string, testDomains []string) ([]int, []DNSTestResult) {
+	if len(dnsServers) == 0 {
+		return nil, nil
+	}
+
+	cfg := newBenchmarkConfig(testDomains, 3*time.Second)
+	appState.AddLog(fmt.Sprintf("Testing all %d DNS servers to find the best one...", len(dnsServers)))
+
+	completed := 0
+	results := benchmarkDNS(context.Background(), dnsServers, cfg, func(idx int, result DNSTestResult) {
+		completed++
 		appState.AddLog(fmt.Sprintf("DNS %d/%d (%s): Avg latency %v, Success rate %.1f%%",
-			idx+1, len(dnsServers), dns, result.AvgLatency, result.SuccessRate))
+			completed, len(dnsServers), result.DNS, result.AvgLatency, result.SuccessRate))
+		if appState.GetDebugMode() {
+			appState.AddLog("  " + result.Stats.String())
+		}
+		if result.Responses.Truncated > 0 {
+			appState.AddLog(fmt.Sprintf("  %s truncated %d UDP answers", result.DNS, result.Responses.Truncated))
+		}
+		if len(result.Addresses) > 1 {
+			for _, addr := range result.Addresses {
+				appState.AddLog(fmt.Sprintf("  %s %s: Avg latency %v, %d/%d resolved",
+					addr.Family, addr.Address, addr.AvgLatency, addr.SuccessCount, addr.TestCount))
+			}
+		}
+		updateLogsDisplay()
+	})
 
+	var candidates []int
+	for idx, result := range results {
 		// Skip DNS servers that completely failed
 		if result.Status == "error" {
-			appState.AddLog(fmt.Sprintf("  Skipping %s: Failed to resolve any domains", dns))
+			appState.AddLog(fmt.Sprintf("  Skipping %s: Failed to resolve any domains", result.DNS))
 			continue
 		}
+		candidates = append(candidates, idx)
+	}
 
-		// If this is the first working DNS, use it as baseline
-		if bestIdx == -1 {
-			bestDNS = dns
-			bestIdx = idx
-			bestLatency = result.AvgLatency
-			bestSuccessRate = result.SuccessRate
-			continue
-		}
+	if len(candidates) == 0 {
+		appState.AddLog("Warning: All DNS servers failed testing")
+		return nil, results
+	}
 
-		// Decision logic:
-		// 1. Prefer DNS with higher success rate (if difference is significant >10%)
-		// 2. If success rates are similar, prefer lower latency
-		// 3. If current has very low success rate (<50%) and candidate is better, switch
-
-		shouldUseThis := false
-
-		if result.SuccessRate < 50 && bestSuccessRate >= 50 {
-			// Current best is good, candidate is bad - keep best
-			shouldUseThis = false
-		} else if bestSuccessRate < 50 && result.SuccessRate >= 50 {
-			// Current best is bad, candidate is good - switch
-			shouldUseThis = true
-		} else if result.SuccessRate > bestSuccessRate+10 {
-			// Candidate has significantly better success rate (>10% difference)
-			shouldUseThis = true
-		} else if result.SuccessRate >= bestSuccessRate-10 && result.AvgLatency > 0 {
-			// Success rates are similar (within 10%), compare latency
-			if result.AvgLatency < bestLatency {
-				shouldUseThis = true
+	// Rank by repeatedly picking the best of the remaining candidates
+	var ranking []int
+	for len(candidates) > 0 {
+		best := 0
+		for i := 1; i < len(candidates); i++ {
+			if isBetterDNS(results[candidates[i]], results[candidates[best]]) {
+				best = i
 			}
 		}
+		ranking = append(ranking, candidates[best])
+		candidates = append(candidates[:best], candidates[best+1:]...)
+	}
 
-		if shouldUseThis {
-			bestDNS = dns
-			bestIdx = idx
-			bestLatency = result.AvgLatency
-			bestSuccessRate = result.SuccessRate
+	best := results[ranking[0]]
+	appState.AddLog(fmt.Sprintf("Best DNS selected: %s (latency: %v, success rate: %.1f%%, ranked by %s)",
+		best.DNS, best.AvgLatency, best.SuccessRate, rankBy()))
+
+	return ranking, results
+}
+
+// isBetterDNS reports whether candidate should be preferred over best
+func isBetterDNS(candidate, best DNSTestResult) bool {
+	// Decision logic:
+	// 1. Prefer DNS with higher success rate (if difference is significant >10%)
+	// 2. If success rates are similar, prefer lower latency
+	// 3. If current has very low success rate (<50%) and candidate is better, switch
+
+	if candidate.SuccessRate < 50 && best.SuccessRate >= 50 {
+		// Current best is good, candidate is bad - keep best
+		return false
+	} else if best.SuccessRate < 50 && candidate.SuccessRate >= 50 {
+		// Current best is bad, candidate is good - switch
+		return true
+	} else if candidate.SuccessRate > best.SuccessRate+10 {
+		// Candidate has significantly better success rate (>10% difference)
+		return true
+	} else if candidate.SuccessRate >= best.SuccessRate-10 && candidate.AvgLatency > 0 {
+		// Success rates are similar (within 10%), compare the statistic chosen by rank_by
+		statistic := rankBy()
+		c, b := rankingScore(candidate, statistic), rankingScore(best, statistic)
+		if c != b {
+			return c < b
 		}
+		return candidate.AvgLatency < best.AvgLatency
 	}
+	return false
+}
 
-	if bestIdx == -1 {
-		// All DNS servers failed, fallback to first one
-		appState.AddLog("Warning: All DNS servers failed testing, using first DNS as fallback")
-		return dnsServers[0], 0
+// rankingScore combines the warm and cold values of a statistic, weighted by cold_weight;
+// lower is better
+func rankingScore(result DNSTestResult, statistic string) float64 {
+	weight := config.ColdWeight
+	if weight < 0 || result.Cold.Sent == 0 || (statistic != rankByLoss && result.Cold.Sent == result.Cold.Lost) {
+		weight = 0
+	} else if weight > 1 {
+		weight = 1
 	}
-
-	appState.AddLog(fmt.Sprintf("Best DNS selected: %s (latency: %v, success rate: %.1f%%)",
-		bestDNS, bestLatency, bestSuccessRate))
-
-	return bestDNS, bestIdx
+	return (1-weight)*result.Stats.Value(statistic) + weight*result.Cold.Value(statistic)
 }
 
 func min(a, b int) int {
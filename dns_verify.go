@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gen2brain/beeep"
+)
+
+const (
+	// defaultVerifyWindow is how long a DNS change has to prove it works before it is rolled back
+	defaultVerifyWindow = 30 * time.Second
+	// verifyRetryInterval is the pause between verification attempts inside the window
+	verifyRetryInterval = 3 * time.Second
+	// verifyLookupTimeout bounds a single test resolution through the system resolver
+	verifyLookupTimeout = 5 * time.Second
+	// verifyMaxDomains limits how many test domains are resolved per attempt
+	verifyMaxDomains = 3
+)
+
+var (
+	verifyMu         sync.Mutex
+	verifyGeneration int // incremented by every change, so a stale verification never rolls back a newer one
+)
+
+// applyAndVerifyDNS applies servers and then checks in the background that DNS
+// still works through the system resolver. If it doesn't within the verify window,
+// the previous resolvers are put back.
+func applyAndVerifyDNS(servers []string, primaryIdx int) error {
+	previous, previousIdx := appState.GetCurrentDNS()
+	if err := applyDNS(servers, primaryIdx); err != nil {
+		return err
+	}
+
+	window := verifyWindow()
+	if appState.GetDryRun() || window <= 0 {
+		return nil
+	}
+	applied, _ := appState.GetCurrentDNS()
+	generation := nextVerifyGeneration()
+	go verifyDNSChange(generation, applied, previous, previousIdx, window)
+	return nil
+}
+
+// cancelDNSVerification stops a pending verification from rolling back, e.g. because DNS was restored
+func cancelDNSVerification() {
+	nextVerifyGeneration()
+}
+
+func nextVerifyGeneration() int {
+	verifyMu.Lock()
+	defer verifyMu.Unlock()
+	verifyGeneration++
+	return verifyGeneration
+}
+
+func isCurrentVerifyGeneration(generation int) bool {
+	verifyMu.Lock()
+	defer verifyMu.Unlock()
+	return generation == verifyGeneration
+}
+
+// verifyWindow returns the configured verification window; zero or less disables verification
+func verifyWindow() time.Duration {
+	switch {
+	case config.VerifyWindowSeconds < 0:
+		return 0
+	case config.VerifyWindowSeconds == 0:
+		return defaultVerifyWindow
+	default:
+		return time.Duration(config.VerifyWindowSeconds) * time.Second
+	}
+}
+
+// verifyDNSChange retries verification until it passes or the window runs out,
+// then rolls back to the previous resolvers
+func verifyDNSChange(generation int, applied, previous []string, previousIdx int, window time.Duration) {
+	deadline := time.Now().Add(window)
+	var lastErr error
+	for attempt := 1; ; attempt++ {
+		if !isCurrentVerifyGeneration(generation) {
+			return
+		}
+		lastErr = verifyAppliedDNS(applied)
+		if lastErr == nil {
+			if appState.GetDebugMode() || attempt > 1 {
+				appState.AddLog(fmt.Sprintf("Verified DNS change to %s (attempt %d)", strings.Join(applied, ", "), attempt))
+				updateLogsDisplay()
+			}
+			return
+		}
+		if appState.GetDebugMode() {
+			appState.AddLog(fmt.Sprintf("DNS verification attempt %d failed: %v", attempt, lastErr))
+		}
+		if time.Now().Add(verifyRetryInterval).After(deadline) {
+			break
+		}
+		time.Sleep(verifyRetryInterval)
+	}
+
+	if !isCurrentVerifyGeneration(generation) {
+		return
+	}
+	rollbackDNSChange(applied, previous, previousIdx, window, lastErr)
+}
+
+// verifyAppliedDNS checks that the system reports the servers we applied and that
+// names resolve through the normal system resolver path
+func verifyAppliedDNS(applied []string) error {
+	if err := verifyReadBack(applied); err != nil {
+		return err
+	}
+
+	testDomains := config.TestDomains
+	if len(testDomains) == 0 {
+		testDomains = defaultTestDomains
+	}
+	testDomains = testDomains[:min(verifyMaxDomains, len(testDomains))]
+
+	var errors []string
+	for _, domain := range testDomains {
+		ctx, cancel := context.WithTimeout(context.Background(), verifyLookupTimeout)
+		// The default resolver goes through the OS (getaddrinfo, the DNS Client service or
+		// the systemd-resolved stub), which is what every other program on the machine uses
+		_, err := net.DefaultResolver.LookupHost(ctx, domain)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound {
+			// An NXDOMAIN answer still proves the resolvers respond
+			return nil
+		}
+		errors = append(errors, fmt.Sprintf("%s: %v", domain, err))
+	}
+	return fmt.Errorf("no test domain resolved through the system resolver (%s)", strings.Join(errors, "; "))
+}
+
+// verifyReadBack checks that the configuration read back from the system contains what we applied
+func verifyReadBack(applied []string) error {
+	if runtime.GOOS == "linux" {
+		servers, err := getLinuxBackend().Current()
+		if err != nil {
+			return fmt.Errorf("failed to read back DNS configuration: %v", err)
+		}
+		// Backends may list the servers in their own order, e.g. IPv4 before IPv6
+		if !hasAllServers(servers, applied) {
+			return fmt.Errorf("system reports %s instead of %s", strings.Join(servers, ", "), strings.Join(applied, ", "))
+		}
+		return nil
+	}
+
+	configs, err := readSystemDNS()
+	if err != nil {
+		return fmt.Errorf("failed to read back DNS configuration: %v", err)
+	}
+	current := systemResolvers(configs)
+	for _, server := range applied {
+		if !containsString(current, server) {
+			return fmt.Errorf("system reports %s, %s is missing", strings.Join(current, ", "), server)
+		}
+	}
+	return nil
+}
+
+// rollbackDNSChange puts the previous resolvers back after a failed verification
+func rollbackDNSChange(applied, previous []string, previousIdx int, window time.Duration, reason error) {
+	message := fmt.Sprintf("DNS change to %s could not be verified within %v: %v", strings.Join(applied, ", "), window, reason)
+
+	var err error
+	if len(previous) > 0 {
+		message += fmt.Sprintf(" - rolling back to %s", strings.Join(previous, ", "))
+		appState.AddLog(message)
+		err = applyDNS(previous, previousIdx)
+	} else {
+		message += " - restoring the original DNS settings"
+		appState.AddLog(message)
+		err = restoreDNS()
+	}
+	if err != nil {
+		message += fmt.Sprintf(" (rollback failed: %v)", err)
+		appState.AddLog(fmt.Sprintf("ERROR: Rollback failed: %v", err))
+	}
+	updateLogsDisplay()
+	updateStatusDisplay()
+
+	if notifyErr := beeep.Notify("DNS Change Rolled Back", message, ""); notifyErr != nil {
+		appState.AddLog(fmt.Sprintf("Warning: Failed to show notification: %v", notifyErr))
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"sort"
@@ -24,6 +25,8 @@ var statusDNSLabel *widget.Label
 var statusStatusLabel *widget.Label
 var statusCountdownLabel *widget.Label
 var statusInterfacesLabel *widget.Label
+var statusManagerLabel *widget.Label
+var statusSystemDNSLabel *widget.Label
 var statusInterfaceSelect *widget.Select
 var statusStartStopBtn *widget.Button
 var statusChangeNowBtn *widget.Button
@@ -38,16 +41,27 @@ var settingsIntervalMinutesEntry *widget.Entry
 var settingsStartupCheck *widget.Check
 var settingsNotifyCheck *widget.Check
 var settingsDebugCheck *widget.Check
+var settingsDryRunCheck *widget.Check
+var settingsRestoreOnExitCheck *widget.Check
 var settingsSaveBtn *widget.Button
 var logsText *widget.RichText
 var logsClearBtn *widget.Button
 var testerResultsList *widget.List
 var testerTestBtn *widget.Button
 var testerStatusLabel *widget.Label
+var testerBaselineCheck *widget.Check
+var testerStopBtn *widget.Button
+var testerCancel context.CancelFunc // stops the running test, nil when idle
 var testerResults []DNSTestResult
+var trayMenu *fyne.Menu
+var trayDNSItem *fyne.MenuItem
 
 var updateTimer *time.Ticker
 
+// pendingRecovery is the journal of a previous run that did not restore DNS,
+// set at startup so the user can be asked once the window is up
+var pendingRecovery *dnsJournal
+
 // setupGUI creates and shows the main GUI window
 func setupGUI() {
 	guiApp = app.NewWithID("com.alternatedns.app")
@@ -83,14 +97,51 @@ func setupGUI() {
 	startUpdateTimer()
 
 	mainWindow.Show()
+
+	if pendingRecovery != nil {
+		showRecoveryDialog(pendingRecovery)
+		pendingRecovery = nil
+	}
+}
+
+// showRecoveryDialog asks whether to restore DNS settings a previous run left behind
+func showRecoveryDialog(journal *dnsJournal) {
+	message := fmt.Sprintf("AlternateDNS did not exit cleanly last time and left these DNS servers applied:\n\n%s\n(via %s, since %s)\n\nRestore the original DNS settings now?",
+		strings.Join(journal.Servers, ", "), journal.Backend, journal.AppliedAt.Format(time.RFC1123))
+	dialog.ShowConfirm("Restore DNS?", message, func(restore bool) {
+		if !restore {
+			// Keep them, but remember how to undo them on Stop or Quit
+			adoptDNSJournal(journal)
+			appState.AddLog("Keeping the DNS settings left by the previous run")
+			updateLogsDisplay()
+			updateStatusDisplay()
+			return
+		}
+		go func() {
+			if err := recoverDNS(journal); err != nil {
+				appState.AddLog(fmt.Sprintf("ERROR: Failed to restore DNS left by the previous run: %v", err))
+				fyne.Do(func() {
+					dialog.ShowError(err, mainWindow)
+				})
+			}
+			updateLogsDisplay()
+			updateStatusDisplay()
+		}()
+	}, mainWindow)
 }
 
 // setupSystemTray sets up the system tray menu using Fyne's native desktop API
 func setupSystemTray() {
 	// Check if the app supports system tray (desktop environment)
 	if desk, ok := guiApp.(desktop.App); ok {
+		// Current DNS, shown as a disabled item at the top of the menu
+		trayDNSItem = fyne.NewMenuItem(formatTrayDNS(), nil)
+		trayDNSItem.Disabled = true
+
 		// Create system tray menu
 		menu := fyne.NewMenu("AlternateDNS",
+			trayDNSItem,
+			fyne.NewMenuItemSeparator(),
 			fyne.NewMenuItem("Open Window", func() {
 				showWindow()
 			}),
@@ -104,8 +155,8 @@ func setupSystemTray() {
 						appState.AddLog(fmt.Sprintf("ERROR: %v", err))
 						updateLogsDisplay()
 					} else {
-						dns, idx := appState.GetCurrentDNS()
-						appState.AddLog(fmt.Sprintf("DNS changed to %s (index %d)", dns, idx))
+						servers, idx := appState.GetCurrentDNS()
+						appState.AddLog(fmt.Sprintf("DNS changed to %s (index %d)", strings.Join(servers, ", "), idx))
 						updateLogsDisplay()
 						updateStatusDisplay()
 					}
@@ -113,31 +164,12 @@ func setupSystemTray() {
 			}),
 			fyne.NewMenuItemSeparator(),
 			fyne.NewMenuItem("Quit", func() {
-				// Stop service if running
-				if appState.IsRunning() {
-					stopService()
-				}
-				// Restore DNS to automatic before exiting
-				if err := restoreDNS(); err != nil {
-					if appState.GetDebugMode() {
-						appState.AddLog(fmt.Sprintf("WARNING: Failed to restore DNS on exit: %v", err))
-					}
-				}
-				appState.SetRunning(false)
-				ticker := appState.GetTicker()
-				if ticker != nil {
-					ticker.Stop()
-					appState.SetTicker(nil)
-				}
-				if appState.GetDebugMode() {
-					appState.AddLog("Exiting the application")
-				}
-				guiApp.Quit()
-				os.Exit(0)
+				shutdown("Quit selected from the tray menu")
 			}),
 		)
 
 		// Set the system tray menu
+		trayMenu = menu
 		desk.SetSystemTrayMenu(menu)
 		// Set system tray icon (uses the app icon)
 		trayIcon := fyne.NewStaticResource("icon.ico", getEmbeddedIcon())
@@ -150,6 +182,28 @@ func setupSystemTray() {
 	}
 }
 
+// formatTrayDNS returns the label of the tray menu item showing the applied DNS servers
+func formatTrayDNS() string {
+	servers, _ := appState.GetCurrentDNS()
+	if len(servers) == 0 {
+		return "DNS: Not Set"
+	}
+	return "DNS: " + strings.Join(servers, ", ")
+}
+
+// updateTrayDNS refreshes the tray menu if the applied DNS servers changed.
+// Must be called on the main thread.
+func updateTrayDNS() {
+	if trayMenu == nil || trayDNSItem == nil {
+		return
+	}
+	label := formatTrayDNS()
+	if trayDNSItem.Label != label {
+		trayDNSItem.Label = label
+		trayMenu.Refresh()
+	}
+}
+
 func createStatusTab() fyne.CanvasObject {
 	// Current DNS display
 	statusDNSLabel = widget.NewLabel("Not Set")
@@ -164,6 +218,21 @@ func createStatusTab() fyne.CanvasObject {
 	statusCountdownLabel = widget.NewLabel("--:--:--")
 	statusCountdownLabel.Alignment = fyne.TextAlignCenter
 
+	// What manages DNS on this system
+	statusManagerLabel = widget.NewLabel("Detecting...")
+	statusManagerLabel.Wrapping = fyne.TextWrapWord
+
+	// Resolvers the system is actually using
+	statusSystemDNSLabel = widget.NewLabel("Not read yet")
+	statusSystemDNSLabel.Wrapping = fyne.TextWrapWord
+	statusSystemDNSRefreshBtn := widget.NewButton("Refresh", func() {
+		go func() {
+			refreshSystemDNS()
+			updateLogsDisplay()
+			updateStatusDisplay()
+		}()
+	})
+
 	// Interfaces
 	statusInterfacesLabel = widget.NewLabel("No interfaces detected")
 	statusInterfacesLabel.Wrapping = fyne.TextWrapWord
@@ -197,8 +266,8 @@ func createStatusTab() fyne.CanvasObject {
 				appState.AddLog(fmt.Sprintf("ERROR: %v", err))
 				updateLogsDisplay()
 			} else {
-				dns, idx := appState.GetCurrentDNS()
-				appState.AddLog(fmt.Sprintf("DNS changed to %s (index %d)", dns, idx))
+				servers, idx := appState.GetCurrentDNS()
+				appState.AddLog(fmt.Sprintf("DNS changed to %s (index %d)", strings.Join(servers, ", "), idx))
 				updateLogsDisplay()
 				updateStatusDisplay()
 			}
@@ -210,6 +279,11 @@ func createStatusTab() fyne.CanvasObject {
 		widget.NewCard("Current DNS", "", statusDNSLabel),
 		widget.NewCard("Service Status", "", statusStatusLabel),
 		widget.NewCard("Next Change In", "", statusCountdownLabel),
+		widget.NewCard("DNS Manager", "", statusManagerLabel),
+		widget.NewCard("System DNS", "", container.NewVBox(
+			statusSystemDNSLabel,
+			statusSystemDNSRefreshBtn,
+		)),
 		widget.NewCard("Active Interfaces", "", container.NewVBox(
 			statusInterfacesLabel,
 			statusInterfaceSelect,
@@ -235,14 +309,14 @@ func createDNSTab() fyne.CanvasObject {
 				return
 			}
 			if id < len(config.DNSAddresses) {
-				currentDNS, currentIdx := appState.GetCurrentDNS()
+				currentServers, currentIdx := appState.GetCurrentDNS()
 				dns := config.DNSAddresses[id]
 				marker := ""
 				if id == currentIdx && appState.IsRunning() {
 					marker = " → "
 				}
 				label.SetText(fmt.Sprintf("%d. %s%s", id+1, dns, marker))
-				if dns == currentDNS {
+				if entryApplied(dns, currentServers) {
 					label.Importance = widget.HighImportance
 				} else {
 					label.Importance = widget.MediumImportance
@@ -333,6 +407,21 @@ func createSettingsTab() fyne.CanvasObject {
 		updateLogsDisplay()
 	}
 
+	settingsRestoreOnExitCheck = widget.NewCheck("Restore DNS on exit", nil)
+	settingsRestoreOnExitCheck.SetChecked(config.RestoreOnExit)
+
+	settingsDryRunCheck = widget.NewCheck("Dry run (describe changes only)", nil)
+	settingsDryRunCheck.SetChecked(appState.GetDryRun())
+	settingsDryRunCheck.OnChanged = func(checked bool) {
+		appState.SetDryRun(checked)
+		appState.AddLog(fmt.Sprintf("Dry run mode: %v", checked))
+		updateLogsDisplay()
+		updateStatusDisplay()
+	}
+	if dryRunLocked() {
+		settingsDryRunCheck.Disable()
+	}
+
 	// Save button
 	settingsSaveBtn = widget.NewButton("Save Settings", func() {
 		saveSettings()
@@ -360,7 +449,9 @@ func createSettingsTab() fyne.CanvasObject {
 		),
 		settingsStartupCheck,
 		settingsNotifyCheck,
+		settingsRestoreOnExitCheck,
 		settingsDebugCheck,
+		settingsDryRunCheck,
 		settingsSaveBtn,
 		widget.NewSeparator(),
 		versionLabel,
@@ -384,7 +475,7 @@ func createLogsTab() fyne.CanvasObject {
 
 func showAddDNSDialog() {
 	entry := widget.NewEntry()
-	entry.SetPlaceHolder("Enter DNS address (e.g., 1.1.1.1)")
+	entry.SetPlaceHolder("Enter DNS address (e.g., 1.1.1.1, 127.0.0.1:5353, 9.9.9.9,2620:fe::fe, tls://1.1.1.1#cloudflare-dns.com, quic://94.140.14.14#dns.adguard-dns.com, https://dns.quad9.net/dns-query or an sdns:// stamp)")
 
 	dialog.ShowForm("Add DNS Server", "Add", "Cancel",
 		[]*widget.FormItem{
@@ -394,6 +485,10 @@ func showAddDNSDialog() {
 			if confirmed {
 				dns := strings.TrimSpace(entry.Text)
 				if dns != "" {
+					if _, err := parseResolverEntry(dns); err != nil {
+						dialog.ShowError(err, mainWindow)
+						return
+					}
 					config.DNSAddresses = append(config.DNSAddresses, dns)
 					saveConfig()
 					dnsList.Refresh()
@@ -436,6 +531,8 @@ func saveSettings() {
 	config.ChangeIntervalHours = 0 // Clear old value
 	config.RunOnStartup = settingsStartupCheck.Checked
 	config.NotifyUser = settingsNotifyCheck.Checked
+	config.DryRun = settingsDryRunCheck.Checked
+	config.RestoreOnExit = settingsRestoreOnExitCheck.Checked
 
 	saveConfig()
 
@@ -449,18 +546,7 @@ func saveSettings() {
 
 	// Restart ticker if running
 	if appState.IsRunning() {
-		ticker := appState.GetTicker()
-		if ticker != nil {
-			ticker.Stop()
-		}
-		var newTicker *time.Ticker
-		if appState.GetDebugMode() {
-			newTicker = time.NewTicker(10 * time.Second)
-		} else {
-			newTicker = time.NewTicker(time.Duration(config.ChangeIntervalMinutes) * time.Minute)
-		}
-		appState.SetTicker(newTicker)
-		go startTickerLoop(newTicker)
+		restartTicker()
 	}
 
 	dialog.ShowInformation("Settings Saved", "Settings have been saved successfully.", mainWindow)
@@ -468,6 +554,45 @@ func saveSettings() {
 	updateLogsDisplay()
 }
 
+// restartTicker replaces the running ticker so a changed interval takes effect
+func restartTicker() {
+	ticker := appState.GetTicker()
+	if ticker != nil {
+		ticker.Stop()
+	}
+	var newTicker *time.Ticker
+	if appState.GetDebugMode() {
+		newTicker = time.NewTicker(10 * time.Second)
+	} else {
+		newTicker = time.NewTicker(time.Duration(config.ChangeIntervalMinutes) * time.Minute)
+	}
+	appState.SetTicker(newTicker)
+	go startTickerLoop(newTicker)
+}
+
+// dryRunLocked reports whether dry run has to stay as it is: switching it while the
+// service runs or our DNS is applied would only describe the restore of real changes
+func dryRunLocked() bool {
+	servers, _ := appState.GetCurrentDNS()
+	return appState.IsRunning() || len(servers) > 0
+}
+
+// refreshSettingsTab shows the current config values, e.g. after it was reloaded
+func refreshSettingsTab() {
+	if mainWindow == nil {
+		return
+	}
+	fyne.Do(func() {
+		settingsIntervalHoursEntry.SetText(fmt.Sprintf("%d", config.ChangeIntervalMinutes/60))
+		settingsIntervalMinutesEntry.SetText(fmt.Sprintf("%d", config.ChangeIntervalMinutes%60))
+		settingsStartupCheck.SetChecked(config.RunOnStartup)
+		settingsNotifyCheck.SetChecked(config.NotifyUser)
+		settingsRestoreOnExitCheck.SetChecked(config.RestoreOnExit)
+		settingsDryRunCheck.SetChecked(appState.GetDryRun())
+		dnsList.Refresh()
+	})
+}
+
 func saveConfig() {
 	configPath, err := getConfigPath()
 	if err != nil {
@@ -496,8 +621,12 @@ func startService() {
 		return
 	}
 
-	// Check admin
+	// Check admin, dry runs only read the system
 	err := checkAdmin()
+	if err != nil && appState.GetDryRun() {
+		appState.AddLog(fmt.Sprintf("Dry run: ignoring %v", err))
+		err = nil
+	}
 	if err != nil {
 		dialog.ShowError(err, mainWindow)
 		appState.AddLog(fmt.Sprintf("ERROR: %v", err))
@@ -590,8 +719,8 @@ func startTickerLoop(ticker *time.Ticker) {
 				}
 			}
 		} else {
-			dns, _ := appState.GetCurrentDNS()
-			appState.AddLog(fmt.Sprintf("DNS changed to %s", dns))
+			servers, _ := appState.GetCurrentDNS()
+			appState.AddLog(fmt.Sprintf("DNS changed to %s", strings.Join(servers, ", ")))
 			updateLogsDisplay()
 		}
 		updateStatusDisplay()
@@ -606,15 +735,26 @@ func updateStatusDisplay() {
 	// All GUI updates must be on main thread
 	fyne.Do(func() {
 		// Update DNS
-		currentDNS, currentIdx := appState.GetCurrentDNS()
-		if currentDNS != "" {
-			statusDNSLabel.SetText(fmt.Sprintf("%s\n(Index: %d)", currentDNS, currentIdx+1))
+		currentServers, currentIdx := appState.GetCurrentDNS()
+		if len(currentServers) > 0 {
+			lines := make([]string, len(currentServers))
+			for i, server := range currentServers {
+				lines[i] = fmt.Sprintf("%s (%s)", server, nameserverRole(i))
+			}
+			statusDNSLabel.SetText(fmt.Sprintf("%s\n(Index: %d)", strings.Join(lines, "\n"), currentIdx+1))
+		} else if len(appState.GetSystemDNS()) > 0 {
+			statusDNSLabel.SetText("Not Set (using system DNS)")
 		} else {
 			statusDNSLabel.SetText("Not Set")
 		}
+		updateTrayDNS()
 
 		// Update status
-		if appState.IsRunning() {
+		if appState.IsRunning() && appState.GetDryRun() {
+			statusStatusLabel.SetText("Running (dry run)")
+			statusStatusLabel.Importance = widget.SuccessImportance
+			statusStartStopBtn.SetText("Stop Service")
+		} else if appState.IsRunning() {
 			statusStatusLabel.SetText("Running")
 			statusStatusLabel.Importance = widget.SuccessImportance
 			statusStartStopBtn.SetText("Stop Service")
@@ -644,6 +784,20 @@ func updateStatusDisplay() {
 			statusCountdownLabel.SetText("--:--:--")
 		}
 
+		// Update DNS manager
+		if manager := appState.GetDNSManager(); manager != "" {
+			statusManagerLabel.SetText(manager)
+		}
+
+		// Update system DNS
+		if systemDNS := appState.GetSystemDNS(); len(systemDNS) > 0 {
+			lines := make([]string, len(systemDNS))
+			for i, c := range systemDNS {
+				lines[i] = c.String()
+			}
+			statusSystemDNSLabel.SetText(strings.Join(lines, "\n"))
+		}
+
 		// Update interfaces
 		interfaces := appState.GetInterfaces()
 		if statusInterfaceSelect != nil {
@@ -687,6 +841,15 @@ func updateStatusDisplay() {
 			}
 		}
 
+		// Dry run can only be switched while none of our settings are in effect
+		if settingsDryRunCheck != nil {
+			if dryRunLocked() {
+				settingsDryRunCheck.Disable()
+			} else {
+				settingsDryRunCheck.Enable()
+			}
+		}
+
 		// Refresh DNS list to show current marker
 		if dnsList != nil {
 			dnsList.Refresh()
@@ -694,6 +857,54 @@ func updateStatusDisplay() {
 	})
 }
 
+// nameserverRole describes the position of a nameserver in the applied list
+func nameserverRole(position int) string {
+	switch position {
+	case 0:
+		return "primary"
+	case 1:
+		return "secondary"
+	case 2:
+		return "tertiary"
+	default:
+		return fmt.Sprintf("fallback %d", position+1)
+	}
+}
+
+// entryApplied reports whether any address of a dns_addresses entry is currently applied
+func entryApplied(dns string, servers []string) bool {
+	entry, err := parseResolverEntry(dns)
+	if err != nil {
+		return false
+	}
+	for _, addr := range entry.Addresses() {
+		if containsString(servers, addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// configuredAddresses returns every address of the dns_addresses entries
+func configuredAddresses() []string {
+	var addrs []string
+	for _, dns := range config.DNSAddresses {
+		if entry, err := parseResolverEntry(dns); err == nil {
+			addrs = append(addrs, entry.Addresses()...)
+		}
+	}
+	return addrs
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
 func updateLogsDisplay() {
 	if logsText == nil {
 		return
@@ -729,8 +940,32 @@ func createDNSTesterTab() fyne.CanvasObject {
 
 	// Test button
 	testerTestBtn = widget.NewButton("Test All DNS Servers", func() {
-		go runDNSTests()
+		// Widgets are only read on the GUI thread
+		go runDNSTests(testerBaselineCheck.Checked)
+	})
+
+	testerStopBtn = widget.NewButton("Stop", func() {
+		if testerCancel != nil {
+			testerCancel()
+		}
 	})
+	testerStopBtn.Disable()
+
+	testerRankSelect := widget.NewSelect(rankByOptions, nil)
+
+	testerTransportSelect := widget.NewSelect(transportOptions, nil)
+	testerTransportSelect.SetSelected(testTransport())
+	testerTransportSelect.OnChanged = func(value string) {
+		if value == config.TestTransport {
+			return
+		}
+		config.TestTransport = value
+		saveConfig()
+		appState.AddLog(fmt.Sprintf("Testing plain DNS servers over %s", value))
+		updateLogsDisplay()
+	}
+
+	testerBaselineCheck = widget.NewCheck("Include current system resolvers as baseline", nil)
 
 	// Results list
 	testerResults = []DNSTestResult{}
@@ -742,8 +977,14 @@ func createDNSTesterTab() fyne.CanvasObject {
 			dnsLabel := widget.NewLabel("")
 			latencyLabel := widget.NewLabel("")
 			successLabel := widget.NewLabel("")
+			familyLabel := widget.NewLabel("")
 			statusLabel := widget.NewLabel("")
-			return container.NewHBox(dnsLabel, latencyLabel, successLabel, statusLabel)
+			statsLabel := widget.NewLabel("")
+			statsLabel.TextStyle = fyne.TextStyle{Italic: true}
+			return container.NewVBox(
+				container.NewHBox(dnsLabel, latencyLabel, successLabel, familyLabel, statusLabel),
+				statsLabel,
+			)
 		},
 		func(id widget.ListItemID, obj fyne.CanvasObject) {
 			if id >= len(testerResults) {
@@ -751,24 +992,44 @@ func createDNSTesterTab() fyne.CanvasObject {
 			}
 			result := testerResults[id]
 
-			// Get the HBox container and its children
-			box, ok := obj.(*fyne.Container)
+			// Get the summary row and its children
+			row, ok := obj.(*fyne.Container)
+			if !ok || len(row.Objects) < 2 {
+				return
+			}
+			box, ok := row.Objects[0].(*fyne.Container)
 			if !ok {
 				return
 			}
 			labels := box.Objects
 
+			// Latency distribution, cached names and random names
+			if statsLabel, ok := row.Objects[1].(*widget.Label); ok {
+				statsText := fmt.Sprintf("warm: %s\ncold: %s\n%s", result.Stats, result.Cold, result.Responses)
+				if result.Handshake.Sent > 0 {
+					statsText += fmt.Sprintf("\nhandshake: %s", result.Handshake)
+				}
+				statsLabel.SetText(statsText)
+			}
+
 			// DNS server
 			if len(labels) > 0 {
 				if dnsLabel, ok := labels[0].(*widget.Label); ok {
-					dnsLabel.SetText(result.DNS)
+					if result.Baseline {
+						dnsLabel.SetText(result.DNS + " (system)")
+					} else {
+						dnsLabel.SetText(result.DNS)
+					}
 				}
 			}
 
 			// Latency
 			if len(labels) > 1 {
 				if latencyLabel, ok := labels[1].(*widget.Label); ok {
-					if result.AvgLatency > 0 {
+					if result.AvgLatency > 0 && result.Cold.Sent > result.Cold.Lost {
+						latencyLabel.SetText(fmt.Sprintf("%v warm / %v cold",
+							result.AvgLatency.Round(time.Millisecond), result.Cold.Mean.Round(time.Millisecond)))
+					} else if result.AvgLatency > 0 {
 						latencyLabel.SetText(result.AvgLatency.Round(time.Millisecond).String())
 					} else {
 						latencyLabel.SetText("N/A")
@@ -783,23 +1044,51 @@ func createDNSTesterTab() fyne.CanvasObject {
 				}
 			}
 
-			// Status
+			// Per address family results
 			if len(labels) > 3 {
-				if statusLabel, ok := labels[3].(*widget.Label); ok {
+				if familyLabel, ok := labels[3].(*widget.Label); ok {
+					familyLabel.SetText(formatAddressResults(result.Addresses))
+				}
+			}
+
+			// Status
+			if len(labels) > 4 {
+				if statusLabel, ok := labels[4].(*widget.Label); ok {
 					statusText := result.Status
 					if result.Error != "" {
 						statusText += " (" + result.Error + ")"
 					}
+					if result.Responses.Truncated > 0 {
+						statusText += " · UDP truncated"
+					}
 					statusLabel.SetText(statusText)
 				}
 			}
 		},
 	)
 
+	// Handlers are attached after the initial selection, so building the tab doesn't save the config
+	testerRankSelect.SetSelected(rankBy())
+	testerRankSelect.OnChanged = func(value string) {
+		if value == config.RankBy {
+			return
+		}
+		config.RankBy = value
+		saveConfig()
+		appState.AddLog(fmt.Sprintf("Ranking DNS servers by %s", value))
+		updateLogsDisplay()
+		if testerCancel == nil {
+			sortTestResults(testerResults)
+			testerResultsList.Refresh()
+		}
+	}
+
 	// Layout
 	top := container.NewVBox(
 		testerStatusLabel,
-		testerTestBtn,
+		testerBaselineCheck,
+		container.NewHBox(widget.NewLabel("Rank by:"), testerRankSelect, widget.NewLabel("Transport:"), testerTransportSelect),
+		container.NewHBox(testerTestBtn, testerStopBtn),
 	)
 
 	return container.NewBorder(
@@ -811,7 +1100,22 @@ func createDNSTesterTab() fyne.CanvasObject {
 	)
 }
 
-func runDNSTests() {
+// formatAddressResults summarises IPv4 and IPv6 results of an entry, e.g. "IPv4 12ms | IPv6 failed"
+func formatAddressResults(results []AddressResult) string {
+	parts := make([]string, 0, len(results))
+	for _, r := range results {
+		if r.Working() {
+			parts = append(parts, fmt.Sprintf("%s %s", r.Family, r.AvgLatency.Round(time.Millisecond)))
+		} else {
+			parts = append(parts, fmt.Sprintf("%s failed", r.Family))
+		}
+	}
+	return strings.Join(parts, " | ")
+}
+
+// runDNSTests tests every configured entry, plus the current system resolvers
+// as a baseline if withBaseline is set
+func runDNSTests(withBaseline bool) {
 	fyne.Do(func() {
 		testerStatusLabel.SetText("Testing DNS servers...")
 		testerTestBtn.Disable()
@@ -819,44 +1123,75 @@ func runDNSTests() {
 		testerResultsList.Refresh()
 	})
 
-	testDomains := config.TestDomains
-	if len(testDomains) == 0 {
-		testDomains = defaultTestDomains
+	entries := append([]string(nil), config.DNSAddresses...)
+	baseline := make(map[int]bool)
+	if withBaseline {
+		refreshSystemDNS()
+		for _, server := range systemResolvers(appState.GetSystemDNS()) {
+			if entryApplied(server, configuredAddresses()) {
+				continue
+			}
+			baseline[len(entries)] = true
+			entries = append(entries, server)
+		}
 	}
 
-	results := make([]DNSTestResult, len(config.DNSAddresses))
-
-	for i, dns := range config.DNSAddresses {
-		appState.AddLog(fmt.Sprintf("Testing DNS server: %s", dns))
-		result := testDNSLatency(dns, testDomains, 5*time.Second)
-		results[i] = result
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fyne.Do(func() {
+		testerCancel = cancel
+		testerStopBtn.Enable()
+	})
 
+	appState.AddLog(fmt.Sprintf("Testing %d DNS servers", len(entries)))
+	cfg := newBenchmarkConfig(config.TestDomains, 5*time.Second)
+	var finished []DNSTestResult
+	results := benchmarkDNS(ctx, entries, cfg, func(i int, result DNSTestResult) {
+		result.Baseline = baseline[i]
 		appState.AddLog(fmt.Sprintf("DNS %s: Avg latency %v, Success rate %.1f%%, Status: %s",
-			dns, result.AvgLatency, result.SuccessRate, result.Status))
+			result.DNS, result.AvgLatency, result.SuccessRate, result.Status))
 
-		// Update GUI
+		// Show results as they come in
+		finished = append(finished, result)
+		shown := append([]DNSTestResult(nil), finished...)
 		fyne.Do(func() {
-			testerResults = results[:i+1]
+			testerResults = shown
 			testerResultsList.Refresh()
+			testerStatusLabel.SetText(fmt.Sprintf("Testing DNS servers... %d/%d done", len(shown), len(entries)))
 		})
+	})
+	for i := range results {
+		results[i].Baseline = baseline[i]
 	}
+	stopped := ctx.Err() != nil
 
-	// Sort by latency (best first)
-	sort.Slice(results, func(i, j int) bool {
+	sortTestResults(results)
+
+	fyne.Do(func() {
+		testerResults = results
+		testerResultsList.Refresh()
+		if stopped {
+			testerStatusLabel.SetText(fmt.Sprintf("Testing stopped. Tested %d DNS servers.", len(results)))
+		} else {
+			testerStatusLabel.SetText(fmt.Sprintf("Testing complete. Tested %d DNS servers.", len(results)))
+		}
+		testerCancel = nil
+		testerStopBtn.Disable()
+		testerTestBtn.Enable()
+	})
+}
+
+// sortTestResults orders results by the rank_by statistic, best first, with failed servers last
+func sortTestResults(results []DNSTestResult) {
+	statistic := rankBy()
+	sort.SliceStable(results, func(i, j int) bool {
 		if results[i].Status == "error" {
 			return false
 		}
 		if results[j].Status == "error" {
 			return true
 		}
-		return results[i].AvgLatency < results[j].AvgLatency
-	})
-
-	fyne.Do(func() {
-		testerResults = results
-		testerResultsList.Refresh()
-		testerStatusLabel.SetText(fmt.Sprintf("Testing complete. Tested %d DNS servers.", len(results)))
-		testerTestBtn.Enable()
+		return rankingScore(results[i], statistic) < rankingScore(results[j], statistic)
 	})
 }
 
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Statistics accepted by the rank_by config option
+const (
+	rankByAverage = "average"
+	rankByMin     = "min"
+	rankByMedian  = "median"
+	rankByP90     = "p90"
+	rankByP99     = "p99"
+	rankByMax     = "max"
+	rankByStdDev  = "stddev"
+	rankByJitter  = "jitter"
+	rankByLoss    = "loss"
+)
+
+// rankByOptions lists the statistics results can be ranked by, for the GUI
+var rankByOptions = []string{rankByAverage, rankByMin, rankByMedian, rankByP90, rankByP99, rankByMax, rankByStdDev, rankByJitter, rankByLoss}
+
+const (
+	// defaultTestRounds is how many times each test domain is queried per address
+	defaultTestRounds = 3
+	// defaultColdWeight is the share of cold-cache latency in the ranking score
+	defaultColdWeight = 0.3
+)
+
+// latencyStats describes the distribution of query latencies of a resolver
+type latencyStats struct {
+	Min    time.Duration
+	Median time.Duration
+	P90    time.Duration
+	P99    time.Duration
+	Max    time.Duration
+	Mean   time.Duration
+	StdDev time.Duration
+	Jitter time.Duration // mean difference between consecutive queries
+	Sent   int
+	Lost   int
+	Loss   float64 // percentage of queries without an answer
+}
+
+// computeLatencyStats summarises the latencies of the answered queries, in the
+// order they were sent, out of sent queries in total
+func computeLatencyStats(samples []time.Duration, sent int) latencyStats {
+	stats := latencyStats{Sent: sent, Lost: sent - len(samples)}
+	if sent > 0 {
+		stats.Loss = float64(stats.Lost) / float64(sent) * 100
+	}
+	if len(samples) == 0 {
+		return stats
+	}
+
+	var total, jitter time.Duration
+	for i, s := range samples {
+		total += s
+		if i > 0 {
+			diff := s - samples[i-1]
+			if diff < 0 {
+				diff = -diff
+			}
+			jitter += diff
+		}
+	}
+	stats.Mean = total / time.Duration(len(samples))
+	if len(samples) > 1 {
+		stats.Jitter = jitter / time.Duration(len(samples)-1)
+	}
+
+	var variance float64
+	for _, s := range samples {
+		d := float64(s - stats.Mean)
+		variance += d * d
+	}
+	stats.StdDev = time.Duration(math.Sqrt(variance / float64(len(samples))))
+
+	sorted := append([]time.Duration(nil), samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	stats.Min = sorted[0]
+	stats.Max = sorted[len(sorted)-1]
+	stats.Median = percentile(sorted, 50)
+	stats.P90 = percentile(sorted, 90)
+	stats.P99 = percentile(sorted, 99)
+	return stats
+}
+
+// percentile returns the nearest-rank percentile of sorted samples
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// Value returns the statistic named by rankBy, as a number where lower is better
+func (s latencyStats) Value(rankBy string) float64 {
+	switch rankBy {
+	case rankByMin:
+		return float64(s.Min)
+	case rankByMedian:
+		return float64(s.Median)
+	case rankByP90:
+		return float64(s.P90)
+	case rankByP99:
+		return float64(s.P99)
+	case rankByMax:
+		return float64(s.Max)
+	case rankByStdDev:
+		return float64(s.StdDev)
+	case rankByJitter:
+		return float64(s.Jitter)
+	case rankByLoss:
+		return s.Loss
+	default:
+		return float64(s.Mean)
+	}
+}
+
+// String returns a compact summary for the DNS Tester
+func (s latencyStats) String() string {
+	if s.Sent == s.Lost {
+		return fmt.Sprintf("loss %.0f%%", s.Loss)
+	}
+	r := func(d time.Duration) time.Duration { return d.Round(100 * time.Microsecond) }
+	return fmt.Sprintf("min %v · med %v · p90 %v · p99 %v · max %v · σ %v · jitter %v · loss %.1f%%",
+		r(s.Min), r(s.Median), r(s.P90), r(s.P99), r(s.Max), r(s.StdDev), r(s.Jitter), s.Loss)
+}
+
+// rankBy returns the configured rank_by statistic, defaulting to the average
+func rankBy() string {
+	for _, option := range rankByOptions {
+		if config.RankBy == option {
+			return option
+		}
+	}
+	return rankByAverage
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestComputeLatencyStats(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		name    string
+		samples []time.Duration
+		sent    int
+		want    latencyStats
+	}{
+		{
+			name: "nothing sent",
+			want: latencyStats{},
+		},
+		{
+			name: "all lost",
+			sent: 4,
+			want: latencyStats{Sent: 4, Lost: 4, Loss: 100},
+		},
+		{
+			name:    "single sample",
+			samples: []time.Duration{7 * ms},
+			sent:    1,
+			want: latencyStats{
+				Min: 7 * ms, Median: 7 * ms, P90: 7 * ms, P99: 7 * ms, Max: 7 * ms, Mean: 7 * ms,
+				Sent: 1,
+			},
+		},
+		{
+			name:    "unsorted with loss",
+			samples: []time.Duration{30 * ms, 10 * ms, 20 * ms, 40 * ms},
+			sent:    5,
+			want: latencyStats{
+				Min: 10 * ms, Median: 20 * ms, P90: 40 * ms, P99: 40 * ms, Max: 40 * ms, Mean: 25 * ms,
+				// sqrt(((-15)² + (-5)² + 5² + 15²) / 4) ≈ 11.18ms
+				StdDev: 11180339 * time.Nanosecond,
+				// (20 + 10 + 20) / 3
+				Jitter: 16666666 * time.Nanosecond,
+				Sent:   5, Lost: 1, Loss: 20,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := computeLatencyStats(tt.samples, tt.sent); got != tt.want {
+				t.Errorf("computeLatencyStats(%v, %d) =\n%+v, want\n%+v", tt.samples, tt.sent, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestComputeLatencyStatsKeepsSamples(t *testing.T) {
+	samples := []time.Duration{3, 1, 2}
+	computeLatencyStats(samples, len(samples))
+	if samples[0] != 3 || samples[1] != 1 || samples[2] != 2 {
+		t.Errorf("samples were reordered: %v", samples)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	sorted := make([]time.Duration, 100)
+	for i := range sorted {
+		sorted[i] = time.Duration(i + 1)
+	}
+	for _, tt := range []struct {
+		p    float64
+		want time.Duration
+	}{
+		{0, 1},
+		{1, 1},
+		{50, 50},
+		{90, 90},
+		{99, 99},
+		{100, 100},
+	} {
+		if got := percentile(sorted, tt.p); got != tt.want {
+			t.Errorf("percentile(1..100, %v) = %v, want %v", tt.p, got, tt.want)
+		}
+	}
+}
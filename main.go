@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
 	"os"
@@ -21,10 +22,30 @@ type Config struct {
 	ChangeIntervalHours   int      `yaml:"change_interval_hours"`   // Deprecated: kept for backward compatibility
 	ChangeIntervalMinutes int      `yaml:"change_interval_minutes"` // New: interval in minutes
 	NotifyUser            bool     `yaml:"notify_user"`
-	TestDomains           []string `yaml:"test_domains"` // Domains used for DNS latency testing
+	TestDomains           []string `yaml:"test_domains"`          // Domains used for DNS latency testing
+	MaxNameservers        int      `yaml:"max_nameservers"`       // How many ranked servers to apply (primary, secondary, ...)
+	LinuxBackend          string   `yaml:"linux_backend"`         // auto, networkmanager, systemd-resolved, resolvconf or file
+	DriftMode             string   `yaml:"drift_mode"`            // log, notify or reapply when something else rewrites DNS
+	DryRun                bool     `yaml:"dry_run"`               // Describe system changes in the logs instead of making them
+	RestoreOnExit         bool     `yaml:"restore_on_exit"`       // Put the original DNS back when quitting or on SIGTERM/SIGINT
+	TestConcurrency       int      `yaml:"test_concurrency"`      // Resolver addresses tested in parallel
+	TestDeadlineSeconds   int      `yaml:"test_deadline_seconds"` // Upper bound for a whole test run
+	TestRounds            int      `yaml:"test_rounds"`           // Queries per test domain and resolver address
+	RankBy                string   `yaml:"rank_by"`               // Statistic used to rank resolvers: average, min, median, p90, p99, max, stddev, jitter or loss
+	ColdTestZones         []string `yaml:"cold_test_zones"`       // Zones random names are queried under to measure uncached lookups (default: test_domains)
+	ColdWeight            float64  `yaml:"cold_weight"`           // Share of the cold-cache figure in the ranking score, 0 to 1
+	TestQueryType         string   `yaml:"test_query_type"`       // Record type the tests query: A, AAAA, HTTPS, MX, ...
+	TestTransport         string   `yaml:"test_transport"`        // How plain DNS entries are tested: udp, tcp or udp+tcp (UDP with TCP fallback)
+	VerifyWindowSeconds   int      `yaml:"verify_window_seconds"` // Seconds a change has to prove it works before it is rolled back (0 = 30, negative disables)
 }
 
+// defaultMaxNameservers matches the number of nameservers resolv.conf honours
+const defaultMaxNameservers = 3
+
 var config Config
+
+// forceDryRun is set by --dry-run, which wins over dry_run in the config
+var forceDryRun bool
 var appIcon []byte
 
 // Custom writer that redirects to appState logs
@@ -48,8 +69,12 @@ func main() {
 	// Redirect standard log output to appState (all log.Printf, log.Println, etc. will go to Logs tab)
 	log.SetOutput(logWriter{})
 
+	debugFlag := flag.Bool("debug", false, "enable debug mode (short change interval, verbose logs)")
+	dryRunFlag := flag.Bool("dry-run", false, "describe DNS and startup changes instead of making them")
+	flag.Parse()
+
 	// Check for debug flag
-	if len(os.Args) > 1 && os.Args[1] == "--debug" {
+	if *debugFlag {
 		appState.SetDebugMode(true)
 		appState.AddLog("Debug mode enabled via command line")
 	}
@@ -69,9 +94,15 @@ func main() {
 
 	// Debug mode will be set via GUI or command line flag
 
+	forceDryRun = *dryRunFlag
+	if forceDryRun || config.DryRun {
+		appState.SetDryRun(true)
+		log.Printf("Dry run mode: system changes will be described in the logs but not made")
+	}
+
 	// Get active interfaces
-	if runtime.GOOS == "windows" {
-		ifaces, err := getActiveWindowsInterfaces()
+	if runtime.GOOS == "windows" || runtime.GOOS == "linux" {
+		ifaces, err := getActiveInterfaces()
 		if err != nil {
 			appState.AddLog(fmt.Sprintf("Warning: Failed to get active interfaces: %v", err))
 		} else {
@@ -84,6 +115,37 @@ func main() {
 		appState.AddLog("Note: macOS support is experimental")
 	}
 
+	// Find out what manages DNS so applyDNS/restoreDNS use the right mechanism
+	if runtime.GOOS == "linux" {
+		selectLinuxBackend()
+	} else {
+		appState.SetDNSManager(describeSystemDNSManager(runtime.GOOS))
+	}
+
+	// Show what the system resolves through before we change anything
+	refreshSystemDNS()
+
+	// A journal left behind means the last run never restored DNS
+	journal, err := loadDNSJournal()
+	if err != nil {
+		appState.AddLog(fmt.Sprintf("Warning: Failed to read DNS journal: %v", err))
+	} else if journal != nil && journal.LeftApplied {
+		// Kept on purpose, so only remember how to undo it on Stop or Quit
+		appState.AddLog(fmt.Sprintf("The previous run left %s applied via %s (restore_on_exit is off), taking it over",
+			strings.Join(journal.Servers, ", "), journal.Backend))
+		adoptDNSJournal(journal)
+	} else if journal != nil {
+		appState.AddLog(fmt.Sprintf("The previous run (PID %d) exited without restoring DNS; %s is still applied via %s",
+			journal.PID, strings.Join(journal.Servers, ", "), journal.Backend))
+		if isHeadless() {
+			if err := recoverDNS(journal); err != nil {
+				appState.AddLog(fmt.Sprintf("ERROR: Failed to restore DNS left by the previous run: %v", err))
+			}
+		} else {
+			pendingRecovery = journal
+		}
+	}
+
 	// Set startup if configured
 	if config.RunOnStartup {
 		err = setRunOnStartup()
@@ -92,6 +154,9 @@ func main() {
 		}
 	}
 
+	// Stop cleanly on kill or logout instead of leaving our DNS behind
+	handleSignals()
+
 	// Initialize GUI on main thread (Fyne requirement)
 	// This will also set up the system tray using Fyne's native API
 	setupGUI()
@@ -155,10 +220,14 @@ func readConfig() error {
 		return err
 	}
 
-	err = yaml.Unmarshal(data, &config)
+	// Start from a clean config so keys removed before a reload don't linger;
+	// restore_on_exit and cold_weight keep their defaults in configs written before they existed
+	newConfig := Config{RestoreOnExit: true, ColdWeight: defaultColdWeight}
+	err = yaml.Unmarshal(data, &newConfig)
 	if err != nil {
 		return err
 	}
+	config = newConfig
 
 	// Handle backward compatibility: convert hours to minutes if needed
 	if config.ChangeIntervalMinutes <= 0 {
@@ -171,17 +240,33 @@ func readConfig() error {
 			config.ChangeIntervalMinutes = 360
 		}
 	}
+
+	if config.MaxNameservers <= 0 {
+		config.MaxNameservers = defaultMaxNameservers
+	}
+	if config.LinuxBackend == "" {
+		config.LinuxBackend = backendAuto
+	}
+	if config.DriftMode == "" {
+		config.DriftMode = driftModeLog
+	}
+	if config.RankBy == "" {
+		config.RankBy = rankByAverage
+	}
+	if config.TestTransport == "" {
+		config.TestTransport = transportUDP
+	}
 	return nil
 }
 
-// changeDNS changes the DNS server. If forceChange is true, it switches to the next DNS
-// without latency testing. If false, it tests all DNS servers and uses the one with lowest latency.
+// changeDNS changes the DNS servers. If forceChange is true, it switches to the next DNS
+// without latency testing. If false, it tests all DNS servers and applies them ranked by latency.
 func changeDNS(forceChange bool) error {
 	if len(config.DNSAddresses) == 0 {
 		return fmt.Errorf("no DNS addresses specified in config")
 	}
 
-	_, currentIdx := appState.GetCurrentDNS()
+	currentServers, currentIdx := appState.GetCurrentDNS()
 
 	// If force change, skip latency testing and switch to next DNS immediately
 	if forceChange {
@@ -189,46 +274,125 @@ func changeDNS(forceChange bool) error {
 			currentIdx = 0
 		}
 		nextIndex := (currentIdx + 1) % len(config.DNSAddresses)
-		nextDNS := config.DNSAddresses[nextIndex]
-		appState.AddLog(fmt.Sprintf("Force changing DNS to %s", nextDNS))
-		return applyDNS(nextDNS, nextIndex)
+		// The following entries in config order act as fallbacks
+		var order []int
+		for i := 0; i < len(config.DNSAddresses); i++ {
+			order = append(order, (nextIndex+i)%len(config.DNSAddresses))
+		}
+		// Entries that can't be applied (DoH, DoQ, DNSCrypt) are skipped, the primary is the first one that can
+		servers, primaryIdx := selectNameservers(order, nil)
+		appState.AddLog(fmt.Sprintf("Force changing DNS to %s", strings.Join(servers, ", ")))
+		return applyAndVerifyDNS(servers, primaryIdx)
 	}
 
-	// Smart switching: Test all DNS servers and use the one with lowest latency
+	// Smart switching: Test all DNS servers and apply the best performing ones in order
 	// This applies to both service start and automatic timer-based changes
 	testDomains := config.TestDomains
 	if len(testDomains) == 0 {
 		testDomains = defaultTestDomains
 	}
 
-	bestDNS, bestIdx := findBestDNS(config.DNSAddresses, testDomains)
+	ranking, results := findBestDNS(config.DNSAddresses, testDomains)
 
-	if bestIdx < 0 {
-		// Fallback: use first DNS if all tests failed
-		bestDNS = config.DNSAddresses[0]
-		bestIdx = 0
-		appState.AddLog(fmt.Sprintf("Using fallback DNS: %s", bestDNS))
+	var servers []string
+	var bestIdx int
+	if len(ranking) > 0 {
+		servers, bestIdx = selectNameservers(ranking, results)
+	}
+	if len(servers) == 0 {
+		// Fallback: use DNS in config order if all tests failed, or only entries that
+		// can't be applied (DoH, DoQ, DNSCrypt) worked
+		var order []int
+		for i := range config.DNSAddresses {
+			order = append(order, i)
+		}
+		servers, bestIdx = selectNameservers(order, nil)
+		appState.AddLog(fmt.Sprintf("Using fallback DNS: %s", strings.Join(servers, ", ")))
 	} else {
 		// Check if we're switching from current DNS
-		if currentIdx >= 0 && currentIdx < len(config.DNSAddresses) {
-			currentDNS := config.DNSAddresses[currentIdx]
-			if bestDNS != currentDNS {
-				appState.AddLog(fmt.Sprintf("Switching from %s to %s (better performance)", currentDNS, bestDNS))
+		if len(currentServers) > 0 {
+			if strings.Join(servers, ",") != strings.Join(currentServers, ",") {
+				appState.AddLog(fmt.Sprintf("Switching from %s to %s (better performance)",
+					strings.Join(currentServers, ", "), strings.Join(servers, ", ")))
 			} else {
-				appState.AddLog(fmt.Sprintf("Keeping current DNS (%s) - it's the best performing", bestDNS))
+				appState.AddLog(fmt.Sprintf("Keeping current DNS (%s) - it's the best performing", strings.Join(servers, ", ")))
 			}
 		} else {
-			appState.AddLog(fmt.Sprintf("Setting DNS to %s (best performing)", bestDNS))
+			appState.AddLog(fmt.Sprintf("Setting DNS to %s (best performing first)", strings.Join(servers, ", ")))
 		}
 	}
 
-	return applyDNS(bestDNS, bestIdx)
+	return applyAndVerifyDNS(servers, bestIdx)
+}
+
+// selectNameservers returns up to config.MaxNameservers addresses, following the given
+// order of config indexes and skipping duplicates. Entries can contribute several
+// addresses (e.g. a dual-stack pair); when test results are given, addresses that
+// failed every test (such as IPv6 on a host without IPv6 connectivity) are left out.
+// DNS-over-TLS servers are only used where the system can apply them, and never
+// together with plain ones: the first address picked decides which kind is used.
+// DNS-over-QUIC, DNSCrypt and DNS-over-HTTPS resolvers are only tested.
+// The config index of the entry that provided the first server is returned with
+// them, or -1 if there are none.
+func selectNameservers(order []int, results []DNSTestResult) ([]string, int) {
+	limit := config.MaxNameservers
+	if limit <= 0 {
+		limit = defaultMaxNameservers
+	}
+	dot := canApplyDNSOverTLS()
+	var servers []string
+	var useTLS bool
+	primaryIdx := -1
+	seen := make(map[string]bool)
+	for _, idx := range order {
+		entry, err := parseResolverEntry(config.DNSAddresses[idx])
+		if err != nil {
+			appState.AddLog(fmt.Sprintf("Skipping DNS entry %q: %v", config.DNSAddresses[idx], err))
+			continue
+		}
+		if entry.DoH != nil {
+			// Only tested for comparison; system resolvers speak plain DNS
+			continue
+		}
+		for _, a := range entry.Addrs {
+			if len(servers) >= limit {
+				return servers, primaryIdx
+			}
+			addr := a.String()
+			if a.testOnly() || (a.TLS && !dot) || seen[addr] || (results != nil && !addressWorked(results[idx], addr)) {
+				continue
+			}
+			if len(servers) == 0 {
+				useTLS = a.TLS
+				primaryIdx = idx
+			} else if a.TLS != useTLS {
+				continue
+			}
+			seen[addr] = true
+			servers = append(servers, addr)
+		}
+	}
+	return servers, primaryIdx
+}
+
+// addressWorked reports whether an address resolved anything in the given test result
+func addressWorked(result DNSTestResult, addr string) bool {
+	for _, r := range result.Addresses {
+		if r.Address == addr {
+			return r.Working()
+		}
+	}
+	return false
 }
 
 // restoreDNS restores DNS settings to automatic/DHCP
 func restoreDNS() error {
 	var allErrors []string
 
+	// Our own restore is not drift, and nothing is left to verify
+	stopDriftWatcher()
+	cancelDNSVerification()
+
 	switch runtime.GOOS {
 	case "windows":
 		activeInterfaces, err := getActiveWindowsInterfaces()
@@ -258,8 +422,7 @@ func restoreDNS() error {
 
 		for _, iface := range targetInterfaces {
 			// Reset DNS to automatic (DHCP)
-			cmd := exec.Command("powershell", "Set-DnsClientServerAddress", "-InterfaceAlias", iface, "-ResetServerAddresses")
-			output, err := cmd.CombinedOutput()
+			output, err := runSystemCommand("", "powershell", "Set-DnsClientServerAddress", "-InterfaceAlias", iface, "-ResetServerAddresses")
 			if err != nil {
 				errMsg := fmt.Sprintf("Error restoring DNS for interface %s: %v. Output: %s", iface, err, string(output))
 				allErrors = append(allErrors, errMsg)
@@ -269,23 +432,26 @@ func restoreDNS() error {
 			}
 		}
 	case "linux":
-		// On Linux, we need to restore the original resolv.conf
-		// This is complex as we'd need to backup the original, so for now we'll just log
-		// The user may need to manually restore or restart network manager
-		appState.AddLog("Note: On Linux, DNS restoration may require manual intervention or network manager restart")
-		// Try to use systemd-resolved if available
-		cmd := exec.Command("sh", "-c", "systemctl is-active --quiet systemd-resolved && sudo systemctl restart systemd-resolved || true")
-		output, err := cmd.CombinedOutput()
+		backend := getLinuxBackend()
+		err := backend.Restore()
 		if err != nil {
-			errMsg := fmt.Sprintf("Note: Could not automatically restore DNS on Linux: %v. Output: %s", err, string(output))
+			errMsg := fmt.Sprintf("Error restoring DNS via %s: %v", backend.Name(), err)
+			allErrors = append(allErrors, errMsg)
 			appState.AddLog(errMsg)
-		} else {
-			appState.AddLog("Attempted to restore DNS via systemd-resolved")
+		}
+		// Put resolv.conf back exactly as it was before our first change. A DNS manager
+		// regenerates the file itself, writing the snapshot would undo its restore.
+		if _, ok := backend.(*resolvConfFileBackend); ok {
+			err = restoreResolvConfSnapshot()
+			if err != nil {
+				errMsg := fmt.Sprintf("Error restoring original %s: %v", resolvConfPath, err)
+				allErrors = append(allErrors, errMsg)
+				appState.AddLog(errMsg)
+			}
 		}
 	case "darwin":
 		// macOS: Reset DNS to automatic
-		cmd := exec.Command("networksetup", "-setdnsservers", "Wi-Fi", "Empty")
-		output, err := cmd.CombinedOutput()
+		output, err := runSystemCommand("", "networksetup", "-setdnsservers", "Wi-Fi", "Empty")
 		if err != nil {
 			errMsg := fmt.Sprintf("Error restoring DNS on macOS: %v. Output: %s", err, string(output))
 			allErrors = append(allErrors, errMsg)
@@ -303,7 +469,11 @@ func restoreDNS() error {
 	}
 
 	// Clear current DNS from state
-	appState.SetCurrentDNS("", -1)
+	appState.SetCurrentDNS(nil, -1)
+	refreshSystemDNS()
+	if err := clearDNSJournal(); err != nil {
+		appState.AddLog(fmt.Sprintf("Warning: Failed to remove DNS journal: %v", err))
+	}
 
 	// Update GUI if available
 	if mainWindow != nil {
@@ -316,12 +486,25 @@ func restoreDNS() error {
 	return nil
 }
 
-// applyDNS applies the DNS settings to the system
-func applyDNS(currentDNS string, currentIdx int) error {
+// applyDNS applies an ordered list of DNS servers to the system. primaryIdx is the
+// config index of the first server.
+func applyDNS(servers []string, primaryIdx int) error {
 	var allErrors []string
 
+	if len(servers) == 0 {
+		return fmt.Errorf("no DNS servers to apply")
+	}
+	serverList := strings.Join(servers, ", ")
+	addrs, err := parseServerList(servers)
+	if err != nil {
+		return err
+	}
+
 	switch runtime.GOOS {
 	case "windows": // fuck you
+		if err := requireStandardPort(addrs, "Windows"); err != nil {
+			return err
+		}
 		activeInterfaces, err := getActiveWindowsInterfaces()
 		if err != nil {
 			return err
@@ -350,34 +533,50 @@ func applyDNS(currentDNS string, currentIdx int) error {
 		}
 
 		for _, iface := range targetInterfaces {
-			cmd := exec.Command("powershell", "Set-DnsClientServerAddress", "-InterfaceAlias", iface, "-ServerAddresses", currentDNS)
-			output, err := cmd.CombinedOutput()
+			output, err := runSystemCommand("", "powershell", "Set-DnsClientServerAddress", "-InterfaceAlias", iface, "-ServerAddresses", quotePowerShellList(servers))
 			if err != nil {
-				errMsg := fmt.Sprintf("Error changing DNS for interface %s to %s: %v. Output: %s", iface, currentDNS, err, string(output))
+				errMsg := fmt.Sprintf("Error changing DNS for interface %s to %s: %v. Output: %s", iface, serverList, err, string(output))
 				allErrors = append(allErrors, errMsg)
 				appState.AddLog(errMsg)
 			} else {
 				if appState.GetDebugMode() {
-					appState.AddLog(fmt.Sprintf("Changed DNS for interface %s to %s", iface, currentDNS))
+					appState.AddLog(fmt.Sprintf("Changed DNS for interface %s to %s", iface, serverList))
 				}
 			}
 		}
 	case "linux": // THE GOAT
-		cmd := exec.Command("sh", "-c", fmt.Sprintf("echo 'nameserver %s' | sudo tee /etc/resolv.conf", currentDNS))
-		if appState.GetDebugMode() {
-			appState.AddLog(fmt.Sprintf("Setting DNS on Linux to %s", currentDNS))
+		// Keep app state in sync
+		if ifaces, err := getActiveLinuxInterfaces(); err == nil {
+			appState.SetInterfaces(ifaces)
+		}
+		backend := getLinuxBackend()
+		// Capture the original resolv.conf before touching anything. Only the file
+		// backend writes it directly, so only that backend puts the snapshot back.
+		if _, ok := backend.(*resolvConfFileBackend); ok {
+			if err := ensureResolvConfSnapshot(); err != nil {
+				return err
+			}
+		}
+		if limiter, ok := backend.(nameserverLimiter); ok && len(servers) > limiter.MaxNameservers() {
+			appState.AddLog(fmt.Sprintf("Warning: %s only honours %d nameservers, ignoring %s",
+				backend.Name(), limiter.MaxNameservers(), strings.Join(servers[limiter.MaxNameservers():], ", ")))
+			servers = servers[:limiter.MaxNameservers()]
+			serverList = strings.Join(servers, ", ")
 		}
-		output, err := cmd.CombinedOutput()
+		err := backend.Apply(servers)
 		if err != nil {
-			errMsg := fmt.Sprintf("Error setting DNS on Linux to %s: %v. Output: %s", currentDNS, err, string(output))
+			errMsg := fmt.Sprintf("Error setting DNS via %s to %s: %v", backend.Name(), serverList, err)
 			allErrors = append(allErrors, errMsg)
 			appState.AddLog(errMsg)
 		}
 	case "darwin": //shitos
-		cmd := exec.Command("networksetup", "-setdnsservers", "Wi-Fi", currentDNS)
-		output, err := cmd.CombinedOutput()
+		if err := requireStandardPort(addrs, "macOS"); err != nil {
+			return err
+		}
+		args := append([]string{"-setdnsservers", "Wi-Fi"}, servers...)
+		output, err := runSystemCommand("", "networksetup", args...)
 		if err != nil {
-			errMsg := fmt.Sprintf("Error setting DNS on macOS to %s: %v. Output: %s", currentDNS, err, string(output))
+			errMsg := fmt.Sprintf("Error setting DNS on macOS to %s: %v. Output: %s", serverList, err, string(output))
 			allErrors = append(allErrors, errMsg)
 			appState.AddLog(errMsg)
 		}
@@ -390,15 +589,29 @@ func applyDNS(currentDNS string, currentIdx int) error {
 		return fmt.Errorf("%s", errorMsg)
 	}
 
-	if config.NotifyUser {
-		err := beeep.Notify("DNS Change", fmt.Sprintf("DNS has been changed to %s", currentDNS), "")
+	if appState.GetDryRun() {
+		// Nothing changed, so there is nothing to notify about or watch
+		dryRunLog(fmt.Sprintf("DNS would now be %s", serverList))
+	} else if config.NotifyUser {
+		err := beeep.Notify("DNS Change", fmt.Sprintf("DNS has been changed to %s", serverList), "")
 		if err != nil {
 			appState.AddLog(fmt.Sprintf("Warning: Failed to show notification: %v", err))
 		}
 	}
 
 	// Update state with new DNS
-	appState.SetCurrentDNS(currentDNS, currentIdx)
+	appState.SetCurrentDNS(servers, primaryIdx)
+	refreshSystemDNS()
+
+	// Remember that DNS is modified, so a later run can undo it if this one dies
+	if err := writeDNSJournal(servers); err != nil {
+		appState.AddLog(fmt.Sprintf("Warning: Failed to write DNS journal: %v", err))
+	}
+
+	// Watch for DHCP, NetworkManager or VPN clients replacing what we wrote
+	if !appState.GetDryRun() {
+		startDriftWatcher()
+	}
 
 	// Calculate next change time
 	var interval time.Duration
@@ -422,6 +635,27 @@ func applyDNS(currentDNS string, currentIdx int) error {
 	return nil
 }
 
+// quotePowerShellList renders values as a PowerShell array literal such as 'a','b'
+func quotePowerShellList(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = "'" + strings.ReplaceAll(v, "'", "''") + "'"
+	}
+	return strings.Join(quoted, ",")
+}
+
+// getActiveInterfaces lists the network interfaces DNS can be applied to on this platform
+func getActiveInterfaces() ([]string, error) {
+	switch runtime.GOOS {
+	case "windows":
+		return getActiveWindowsInterfaces()
+	case "linux":
+		return getActiveLinuxInterfaces()
+	default:
+		return nil, fmt.Errorf("interface selection is not supported on %s", runtime.GOOS)
+	}
+}
+
 func getActiveWindowsInterfaces() ([]string, error) {
 	cmd := exec.Command("powershell", "-NoProfile", "-NonInteractive",
 		"Get-NetAdapter | Where-Object { $_.Status -eq 'Up' } | Select-Object -ExpandProperty Name") // thanks ChatGPT, if I had to go through more powershell errors I would have gone insane.
@@ -469,6 +703,19 @@ func generateDefaultConfig() error {
 		RunOnStartup:          true,
 		ChangeIntervalMinutes: 360, // 6 hours default
 		NotifyUser:            true,
+		MaxNameservers:        defaultMaxNameservers,
+		LinuxBackend:          backendAuto,
+		DriftMode:             driftModeLog,
+		DryRun:                false,
+		VerifyWindowSeconds:   30,
+		RestoreOnExit:         true,
+		TestConcurrency:       defaultTestConcurrency,
+		TestDeadlineSeconds:   int(defaultTestDeadline / time.Second),
+		TestRounds:            defaultTestRounds,
+		RankBy:                rankByAverage,
+		ColdWeight:            defaultColdWeight,
+		TestQueryType:         defaultQueryType,
+		TestTransport:         transportUDP,
 	}
 
 	data, err := yaml.Marshal(&defaultConfig)
@@ -504,8 +751,7 @@ if (Get-ItemProperty -Path $path -Name $name -ErrorAction SilentlyContinue) {
 }
 `, exePath) // why
 
-		cmd := exec.Command("powershell", "-Command", script)
-		output, err := cmd.CombinedOutput()
+		output, err := runSystemCommand("", "powershell", "-Command", script)
 		if err != nil {
 			return fmt.Errorf("failed to set run on startup in registry: %v. Output: %s", err, string(output))
 		}
@@ -522,13 +768,13 @@ Comment=Start DNSChanger on startup
 `, exePath)
 
 		autostartDir := filepath.Join(os.Getenv("HOME"), ".config", "autostart")
-		err := os.MkdirAll(autostartDir, 0755)
+		err := mkdirSystem(autostartDir, 0755)
 		if err != nil {
 			return fmt.Errorf("failed to create autostart directory: %v", err)
 		}
 
 		desktopFilePath := filepath.Join(autostartDir, "DNSChanger.desktop")
-		err = os.WriteFile(desktopFilePath, []byte(desktopFileContent), 0644)
+		err = writeSystemFile(desktopFilePath, []byte(desktopFileContent), 0644, -1, -1)
 		if err != nil {
 			return fmt.Errorf("failed to write .desktop file: %v", err)
 		}
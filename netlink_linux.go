@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+// defaultRouteInterface returns the name of the interface carrying the default
+// route with the lowest metric, asking the kernel over rtnetlink. IPv4 routes win ties.
+func defaultRouteInterface() (string, error) {
+	bestIndex := 0
+	var bestPriority uint32
+
+	for _, family := range []int{syscall.AF_INET, syscall.AF_INET6} {
+		data, err := syscall.NetlinkRIB(syscall.RTM_GETROUTE, family)
+		if err != nil {
+			return "", fmt.Errorf("failed to dump routes: %v", err)
+		}
+		msgs, err := syscall.ParseNetlinkMessage(data)
+		if err != nil {
+			return "", fmt.Errorf("failed to parse routes: %v", err)
+		}
+
+		for _, msg := range msgs {
+			if msg.Header.Type == syscall.NLMSG_DONE {
+				break
+			}
+			if msg.Header.Type != syscall.RTM_NEWROUTE || len(msg.Data) < syscall.SizeofRtMsg {
+				continue
+			}
+			rtm := (*syscall.RtMsg)(unsafe.Pointer(&msg.Data[0]))
+			// A default route has no destination prefix
+			if rtm.Dst_len != 0 || rtm.Table != syscall.RT_TABLE_MAIN || rtm.Type != syscall.RTN_UNICAST {
+				continue
+			}
+			attrs, err := syscall.ParseNetlinkRouteAttr(&msg)
+			if err != nil {
+				continue
+			}
+
+			index := 0
+			var priority uint32
+			for _, attr := range attrs {
+				if len(attr.Value) < 4 {
+					continue
+				}
+				switch attr.Attr.Type {
+				case syscall.RTA_OIF:
+					index = int(binary.NativeEndian.Uint32(attr.Value))
+				case syscall.RTA_PRIORITY:
+					priority = binary.NativeEndian.Uint32(attr.Value)
+				}
+			}
+			if index == 0 {
+				continue
+			}
+			if bestIndex == 0 || priority < bestPriority {
+				bestIndex = index
+				bestPriority = priority
+			}
+		}
+	}
+
+	if bestIndex == 0 {
+		return "", fmt.Errorf("no default route found")
+	}
+	iface, err := net.InterfaceByIndex(bestIndex)
+	if err != nil {
+		return "", err
+	}
+	return iface.Name, nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "fmt"
+
+// defaultRouteInterface is only implemented on Linux, where it uses rtnetlink
+func defaultRouteInterface() (string, error) {
+	return "", fmt.Errorf("default route lookup is not supported on this platform")
+}
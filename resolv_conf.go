@@ -0,0 +1,152 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// resolvConfMaxNameservers is the number of nameserver lines the glibc resolver honours (MAXNS)
+const resolvConfMaxNameservers = 3
+
+// resolvConfLine is a single line of resolv.conf. Raw is kept so that lines we
+// don't modify are written back exactly as they were read.
+type resolvConfLine struct {
+	Keyword string   // "nameserver", "search", "domain", "sortlist", "options", or "" for comments, blank and unknown lines
+	Values  []string // arguments following the keyword
+	Raw     string
+}
+
+// resolvConf is an in-memory model of a resolv.conf file
+type resolvConf struct {
+	Lines []resolvConfLine
+}
+
+// parseResolvConf parses resolv.conf contents, keeping every line
+func parseResolvConf(data []byte) *resolvConf {
+	conf := &resolvConf{}
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	text = strings.TrimSuffix(text, "\n")
+	if text == "" {
+		return conf
+	}
+	for _, raw := range strings.Split(text, "\n") {
+		line := resolvConfLine{Raw: raw}
+		trimmed := strings.TrimSpace(raw)
+		if trimmed != "" && trimmed[0] != '#' && trimmed[0] != ';' {
+			fields := strings.Fields(trimmed)
+			switch fields[0] {
+			case "nameserver", "search", "domain", "sortlist", "options":
+				line.Keyword = fields[0]
+				line.Values = fields[1:]
+			}
+		}
+		conf.Lines = append(conf.Lines, line)
+	}
+	return conf
+}
+
+// values returns the arguments of every line with the given keyword, in order
+func (c *resolvConf) values(keyword string) []string {
+	var values []string
+	for _, line := range c.Lines {
+		if line.Keyword == keyword {
+			values = append(values, line.Values...)
+		}
+	}
+	return values
+}
+
+// Nameservers returns the configured nameserver addresses
+func (c *resolvConf) Nameservers() []string {
+	return c.values("nameserver")
+}
+
+// Search returns the search domains. As with glibc, only the last search or domain line counts.
+func (c *resolvConf) Search() []string {
+	var search []string
+	for _, line := range c.Lines {
+		if line.Keyword == "search" || line.Keyword == "domain" {
+			search = line.Values
+		}
+	}
+	return search
+}
+
+// Options returns the resolver options, such as edns0 or trust-ad
+func (c *resolvConf) Options() []string {
+	return c.values("options")
+}
+
+// SetNameservers replaces all nameserver lines with servers. The new lines take
+// the place of the first existing nameserver line, everything else is left alone.
+func (c *resolvConf) SetNameservers(servers []string) {
+	newLines := make([]resolvConfLine, 0, len(servers))
+	for _, server := range servers {
+		newLines = append(newLines, resolvConfLine{
+			Keyword: "nameserver",
+			Values:  []string{server},
+			Raw:     "nameserver " + server,
+		})
+	}
+
+	var lines []resolvConfLine
+	inserted := false
+	for _, line := range c.Lines {
+		if line.Keyword != "nameserver" {
+			lines = append(lines, line)
+			continue
+		}
+		if !inserted {
+			lines = append(lines, newLines...)
+			inserted = true
+		}
+	}
+	if !inserted {
+		lines = append(lines, newLines...)
+	}
+	c.Lines = lines
+}
+
+// Bytes renders the file contents
+func (c *resolvConf) Bytes() []byte {
+	var sb strings.Builder
+	for _, line := range c.Lines {
+		sb.WriteString(line.Raw)
+		sb.WriteByte('\n')
+	}
+	return []byte(sb.String())
+}
+
+// writeFileAtomic replaces path with data by writing a temporary file in the same
+// directory and renaming it over the original, so readers never see a partial file.
+// uid and gid may be -1 to leave the owner of the new file unchanged.
+func writeFileAtomic(path string, data []byte, perm os.FileMode, uid, gid int) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpPath, perm); err != nil {
+		return err
+	}
+	if uid >= 0 || gid >= 0 {
+		if err := os.Chown(tmpPath, uid, gid); err != nil {
+			return err
+		}
+	}
+	return os.Rename(tmpPath, path)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseResolvConf(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        string
+		nameservers []string
+		search      []string
+		options     []string
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name:        "typical",
+			data:        "# Generated\nnameserver 127.0.0.53\noptions edns0 trust-ad\nsearch lan\n",
+			nameservers: []string{"127.0.0.53"},
+			search:      []string{"lan"},
+			options:     []string{"edns0", "trust-ad"},
+		},
+		{
+			name:        "IPv6 and CRLF",
+			data:        "nameserver 9.9.9.9\r\nnameserver 2620:fe::fe\r\nnameserver fe80::1%eth0\r\n",
+			nameservers: []string{"9.9.9.9", "2620:fe::fe", "fe80::1%eth0"},
+		},
+		{
+			name:        "comments, blank and unknown lines",
+			data:        "; comment\n\n  # nameserver 1.1.1.1\nlookup file bind\n\tnameserver\t8.8.8.8  \n",
+			nameservers: []string{"8.8.8.8"},
+		},
+		{
+			name:   "last search or domain line wins",
+			data:   "search a.example b.example\ndomain c.example\n",
+			search: []string{"c.example"},
+		},
+		{
+			name:    "options across lines",
+			data:    "options ndots:2\noptions rotate timeout:1\n",
+			options: []string{"ndots:2", "rotate", "timeout:1"},
+		},
+		{
+			name: "keyword without values",
+			data: "nameserver\nsearch\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := parseResolvConf([]byte(tt.data))
+			if got := conf.Nameservers(); !slices.Equal(got, tt.nameservers) {
+				t.Errorf("Nameservers() = %q, want %q", got, tt.nameservers)
+			}
+			if got := conf.Search(); !slices.Equal(got, tt.search) {
+				t.Errorf("Search() = %q, want %q", got, tt.search)
+			}
+			if got := conf.Options(); !slices.Equal(got, tt.options) {
+				t.Errorf("Options() = %q, want %q", got, tt.options)
+			}
+		})
+	}
+}
+
+func TestResolvConfSetNameservers(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		servers []string
+		want    string
+	}{
+		{
+			name:    "replaces in place",
+			data:    "# Generated\nsearch lan\nnameserver 192.168.1.1\noptions edns0\nnameserver 192.168.1.2\n",
+			servers: []string{"9.9.9.9", "2620:fe::fe"},
+			want:    "# Generated\nsearch lan\nnameserver 9.9.9.9\nnameserver 2620:fe::fe\noptions edns0\n",
+		},
+		{
+			name:    "appends without existing nameservers",
+			data:    "search lan\r\noptions  rotate\r\n",
+			servers: []string{"1.1.1.1"},
+			want:    "search lan\noptions  rotate\nnameserver 1.1.1.1\n",
+		},
+		{
+			name:    "empty file",
+			servers: []string{"1.1.1.1"},
+			want:    "nameserver 1.1.1.1\n",
+		},
+		{
+			name: "removes all nameservers",
+			data: "nameserver 1.1.1.1\n# keep\n",
+			want: "# keep\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := parseResolvConf([]byte(tt.data))
+			conf.SetNameservers(tt.servers)
+			if got := string(conf.Bytes()); got != tt.want {
+				t.Errorf("Bytes() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// defaultDNSPort is the port plain DNS resolvers listen on
+const defaultDNSPort = 53
+
+// defaultDoTPort is the port DNS-over-TLS resolvers listen on (RFC 7858)
+const defaultDoTPort = 853
+
+// dotScheme prefixes DNS-over-TLS addresses, e.g. "tls://1.1.1.1#cloudflare-dns.com"
+const dotScheme = "tls://"
+
+// doqScheme prefixes DNS-over-QUIC addresses, e.g. "quic://94.140.14.14#dns.adguard-dns.com".
+// DoQ uses the DoT port, over UDP (RFC 9250).
+const doqScheme = "quic://"
+
+// defaultDoHPath is the path RFC 8484 suggests for DNS-over-HTTPS endpoints
+const defaultDoHPath = "/dns-query"
+
+// resolverEntry is a parsed dns_addresses entry. An entry can list several
+// addresses of the same provider separated by commas, typically a dual-stack
+// pair such as "9.9.9.9,2620:fe::fe". Addresses may carry a port, e.g.
+// "127.0.0.1:5353" or "[::1]:5300", and use DNS-over-TLS, e.g.
+// "tls://1.1.1.1:853#cloudflare-dns.com", or DNS-over-QUIC, e.g.
+// "quic://94.140.14.14#dns.adguard-dns.com". An sdns:// stamp is a DNSCrypt
+// resolver and an https:// URL a DNS-over-HTTPS endpoint. DoQ, DNSCrypt and DoH
+// can be tested but not set as system nameservers.
+type resolverEntry struct {
+	Raw   string
+	Addrs []resolverAddr
+	DoH   *url.URL // DNS-over-HTTPS endpoint; the entry has no Addrs
+}
+
+// resolverAddr is a single resolver address and the port it listens on
+type resolverAddr struct {
+	IP         net.IP
+	Port       int
+	TLS        bool   // DNS-over-TLS
+	QUIC       bool   // DNS-over-QUIC
+	ServerName string // name the TLS certificate is verified against, the IP if empty
+	DNSCrypt   *dnscryptStamp
+}
+
+// parseResolverEntry parses a dns_addresses entry
+func parseResolverEntry(raw string) (resolverEntry, error) {
+	entry := resolverEntry{Raw: raw}
+	if strings.HasPrefix(strings.ToLower(strings.TrimSpace(raw)), "https://") {
+		endpoint, err := parseDoHURL(strings.TrimSpace(raw))
+		if err != nil {
+			return entry, err
+		}
+		entry.DoH = endpoint
+		return entry, nil
+	}
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		addr, err := parseResolverAddr(part)
+		if err != nil {
+			return entry, err
+		}
+		entry.Addrs = append(entry.Addrs, addr)
+	}
+	if len(entry.Addrs) == 0 {
+		return entry, fmt.Errorf("empty DNS entry")
+	}
+	return entry, nil
+}
+
+// parseResolverAddr parses "ip", "ip:port", "[ipv6]" or "[ipv6]:port", optionally
+// prefixed with tls:// or quic:// and followed by #name for DNS-over-TLS or -QUIC,
+// or a DNSCrypt sdns:// stamp
+func parseResolverAddr(s string) (resolverAddr, error) {
+	if _, ok := cutPrefixFold(s, stampScheme); ok {
+		return parseStamp(s)
+	}
+	if rest, ok := cutPrefixFold(s, dotScheme); ok {
+		hostPort, serverName, _ := strings.Cut(rest, "#")
+		addr, err := parseHostPort(hostPort, defaultDoTPort)
+		if err != nil {
+			return resolverAddr{}, fmt.Errorf("invalid DNS-over-TLS address %q", s)
+		}
+		addr.TLS = true
+		addr.ServerName = serverName
+		return addr, nil
+	}
+	if rest, ok := cutPrefixFold(s, doqScheme); ok {
+		hostPort, serverName, _ := strings.Cut(rest, "#")
+		addr, err := parseHostPort(hostPort, defaultDoTPort)
+		if err != nil {
+			return resolverAddr{}, fmt.Errorf("invalid DNS-over-QUIC address %q", s)
+		}
+		addr.QUIC = true
+		addr.ServerName = serverName
+		return addr, nil
+	}
+	return parseHostPort(s, defaultDNSPort)
+}
+
+// cutPrefixFold returns s without prefix, matched case-insensitively, and whether it was there
+func cutPrefixFold(s, prefix string) (string, bool) {
+	if len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix) {
+		return s[len(prefix):], true
+	}
+	return s, false
+}
+
+// parseHostPort parses an IP address with an optional port
+func parseHostPort(s string, defaultPort int) (resolverAddr, error) {
+	// A bare IPv6 address contains colons but no port
+	if ip := net.ParseIP(s); ip != nil {
+		return resolverAddr{IP: ip, Port: defaultPort}, nil
+	}
+	if strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]") {
+		if ip := net.ParseIP(s[1 : len(s)-1]); ip != nil {
+			return resolverAddr{IP: ip, Port: defaultPort}, nil
+		}
+	}
+
+	host, portStr, err := net.SplitHostPort(s)
+	if err != nil {
+		return resolverAddr{}, fmt.Errorf("invalid DNS address %q", s)
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return resolverAddr{}, fmt.Errorf("invalid DNS address %q", s)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 65535 {
+		return resolverAddr{}, fmt.Errorf("invalid port in DNS address %q", s)
+	}
+	return resolverAddr{IP: ip, Port: port}, nil
+}
+
+// parseDoHURL parses a DNS-over-HTTPS endpoint such as https://dns.quad9.net/dns-query;
+// the path defaults to /dns-query
+func parseDoHURL(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DNS URL %q", s)
+	}
+	if !strings.EqualFold(u.Scheme, "https") {
+		return nil, fmt.Errorf("unsupported DNS URL scheme %q in %q", u.Scheme, s)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("missing host in DNS URL %q", s)
+	}
+	if u.Path == "" {
+		u.Path = defaultDoHPath
+	}
+	return u, nil
+}
+
+// String returns the address in the form used in the config; the port is omitted when
+// it is the default, 53 or 853 for DNS-over-TLS and -QUIC
+func (a resolverAddr) String() string {
+	if a.DNSCrypt != nil {
+		return a.DNSCrypt.Raw
+	}
+	if a.TLS || a.QUIC {
+		scheme := dotScheme
+		if a.QUIC {
+			scheme = doqScheme
+		}
+		s := scheme + a.IP.String()
+		if a.Port != defaultDoTPort {
+			s = scheme + a.HostPort()
+		}
+		if a.ServerName != "" {
+			s += "#" + a.ServerName
+		}
+		return s
+	}
+	if a.Port == defaultDNSPort {
+		return a.IP.String()
+	}
+	return a.HostPort()
+}
+
+// HostPort returns the address in a form suitable for dialing
+func (a resolverAddr) HostPort() string {
+	// JoinHostPort adds the brackets IPv6 addresses need
+	return net.JoinHostPort(a.IP.String(), strconv.Itoa(a.Port))
+}
+
+// Addresses returns the entry's addresses as strings, in the order they were listed
+func (e resolverEntry) Addresses() []string {
+	addrs := make([]string, len(e.Addrs))
+	for i, addr := range e.Addrs {
+		addrs[i] = addr.String()
+	}
+	return addrs
+}
+
+// testOnly reports whether the address can be tested but not applied; no system
+// resolver speaks DNS-over-QUIC or DNSCrypt
+func (a resolverAddr) testOnly() bool {
+	return a.QUIC || a.DNSCrypt != nil
+}
+
+// parseServerList parses a list of applied server addresses, rejecting those
+// that can only be tested
+func parseServerList(servers []string) ([]resolverAddr, error) {
+	addrs := make([]resolverAddr, 0, len(servers))
+	for _, server := range servers {
+		addr, err := parseResolverAddr(server)
+		if err != nil {
+			return nil, err
+		}
+		if addr.testOnly() {
+			return nil, fmt.Errorf("DNS-over-QUIC and DNSCrypt servers can only be tested, cannot apply %s", addr)
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs, nil
+}
+
+// requireStandardPort returns an error if any server listens on a port other
+// than 53 or uses DNS-over-TLS, for system DNS settings that can express neither
+func requireStandardPort(servers []resolverAddr, mechanism string) error {
+	for _, addr := range servers {
+		if addr.TLS {
+			return fmt.Errorf("%s does not support DNS-over-TLS, cannot apply %s", mechanism, addr)
+		}
+		if addr.Port != defaultDNSPort {
+			return fmt.Errorf("%s only supports DNS servers on port %d, cannot apply %s", mechanism, defaultDNSPort, addr)
+		}
+	}
+	return nil
+}
+
+// ipFamily returns "IPv4" or "IPv6" for an address
+func ipFamily(ip net.IP) string {
+	if ip.To4() != nil {
+		return "IPv4"
+	}
+	return "IPv6"
+}
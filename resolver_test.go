@@ -0,0 +1,157 @@
+package main
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestParseResolverEntry(t *testing.T) {
+	tests := []struct {
+		name  string
+		raw   string
+		addrs []resolverAddr
+		doh   string
+	}{
+		{
+			name:  "IPv4",
+			raw:   "9.9.9.9",
+			addrs: []resolverAddr{{IP: net.ParseIP("9.9.9.9"), Port: 53}},
+		},
+		{
+			name:  "IPv4 with port",
+			raw:   "127.0.0.1:5353",
+			addrs: []resolverAddr{{IP: net.ParseIP("127.0.0.1"), Port: 5353}},
+		},
+		{
+			name:  "bare IPv6",
+			raw:   "2620:fe::fe",
+			addrs: []resolverAddr{{IP: net.ParseIP("2620:fe::fe"), Port: 53}},
+		},
+		{
+			name:  "bracketed IPv6",
+			raw:   "[2620:fe::fe]",
+			addrs: []resolverAddr{{IP: net.ParseIP("2620:fe::fe"), Port: 53}},
+		},
+		{
+			name:  "IPv6 with port",
+			raw:   "[::1]:5300",
+			addrs: []resolverAddr{{IP: net.ParseIP("::1"), Port: 5300}},
+		},
+		{
+			name: "dual-stack pair",
+			raw:  " 9.9.9.9 , 2620:fe::fe ,",
+			addrs: []resolverAddr{
+				{IP: net.ParseIP("9.9.9.9"), Port: 53},
+				{IP: net.ParseIP("2620:fe::fe"), Port: 53},
+			},
+		},
+		{
+			name:  "DNS-over-TLS",
+			raw:   "tls://1.1.1.1#cloudflare-dns.com",
+			addrs: []resolverAddr{{IP: net.ParseIP("1.1.1.1"), Port: 853, TLS: true, ServerName: "cloudflare-dns.com"}},
+		},
+		{
+			name:  "DNS-over-TLS IPv6 with port",
+			raw:   "TLS://[2606:4700:4700::1111]:8853",
+			addrs: []resolverAddr{{IP: net.ParseIP("2606:4700:4700::1111"), Port: 8853, TLS: true}},
+		},
+		{
+			name:  "DNS-over-QUIC",
+			raw:   "quic://94.140.14.14#dns.adguard-dns.com",
+			addrs: []resolverAddr{{IP: net.ParseIP("94.140.14.14"), Port: 853, QUIC: true, ServerName: "dns.adguard-dns.com"}},
+		},
+		{
+			name: "DNS-over-QUIC dual-stack with port",
+			raw:  "quic://94.140.14.14:784,Quic://[2a10:50c0::ad1:ff]",
+			addrs: []resolverAddr{
+				{IP: net.ParseIP("94.140.14.14"), Port: 784, QUIC: true},
+				{IP: net.ParseIP("2a10:50c0::ad1:ff"), Port: 853, QUIC: true},
+			},
+		},
+		{
+			name: "DNS-over-HTTPS",
+			raw:  "https://dns.quad9.net",
+			doh:  "https://dns.quad9.net/dns-query",
+		},
+		{
+			name: "DNS-over-HTTPS with path",
+			raw:  " https://cloudflare-dns.com/custom?ct ",
+			doh:  "https://cloudflare-dns.com/custom?ct",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry, err := parseResolverEntry(tt.raw)
+			if err != nil {
+				t.Fatalf("parseResolverEntry(%q): %v", tt.raw, err)
+			}
+			if !reflect.DeepEqual(entry.Addrs, tt.addrs) {
+				t.Errorf("Addrs = %v, want %v", entry.Addrs, tt.addrs)
+			}
+			doh := ""
+			if entry.DoH != nil {
+				doh = entry.DoH.String()
+			}
+			if doh != tt.doh {
+				t.Errorf("DoH = %q, want %q", doh, tt.doh)
+			}
+		})
+	}
+}
+
+func TestParseResolverEntryInvalid(t *testing.T) {
+	for _, raw := range []string{
+		"",
+		" , ",
+		"dns.google",
+		"9.9.9",
+		"9.9.9.9:0",
+		"9.9.9.9:65536",
+		"9.9.9.9:dns",
+		"2620:fe::fe:53:x",
+		"[2620:fe::fe",
+		"9.9.9.9,garbage",
+		"tls://",
+		"tls://cloudflare-dns.com",
+		"quic://",
+		"quic://94.140.14.14:99999",
+		"https://",
+		"https:///dns-query",
+	} {
+		if entry, err := parseResolverEntry(raw); err == nil {
+			t.Errorf("parseResolverEntry(%q) = %v, want an error", raw, entry.Addrs)
+		}
+	}
+}
+
+func TestResolverAddrString(t *testing.T) {
+	for _, s := range []string{
+		"9.9.9.9",
+		"127.0.0.1:5353",
+		"2620:fe::fe",
+		"[::1]:5300",
+		"tls://1.1.1.1#cloudflare-dns.com",
+		"tls://[2606:4700:4700::1111]:8853",
+		"quic://94.140.14.14#dns.adguard-dns.com",
+		"quic://[2a10:50c0::ad1:ff]:784",
+	} {
+		addr, err := parseResolverAddr(s)
+		if err != nil {
+			t.Fatalf("parseResolverAddr(%q): %v", s, err)
+		}
+		if got := addr.String(); got != s {
+			t.Errorf("parseResolverAddr(%q).String() = %q", s, got)
+		}
+	}
+}
+
+func TestParseServerListRejectsQUIC(t *testing.T) {
+	if _, err := parseServerList([]string{"9.9.9.9", "quic://94.140.14.14"}); err == nil {
+		t.Error("parseServerList accepted a DNS-over-QUIC server")
+	}
+	addrs, err := parseServerList([]string{"9.9.9.9", "tls://1.1.1.1"})
+	if err != nil || len(addrs) != 2 {
+		t.Errorf("parseServerList = %v, %v", addrs, err)
+	}
+}
@@ -24,10 +24,15 @@ func getEmbeddedDefaultConfig() []byte {
 
 // getConfigPath returns the path to config.yaml in the executable directory
 func getConfigPath() (string, error) {
+	return getDataPath("config.yaml")
+}
+
+// getDataPath returns the path to a file stored next to config.yaml
+func getDataPath(name string) (string, error) {
 	exePath, err := os.Executable()
 	if err != nil {
 		return "", err
 	}
 	exeDir := filepath.Dir(exePath)
-	return filepath.Join(exeDir, "config.yaml"), nil
+	return filepath.Join(exeDir, name), nil
 }
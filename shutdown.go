@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// sessionLogFile receives the in-memory logs when the app exits
+const sessionLogFile = "alternatedns.log"
+
+var shutdownOnce sync.Once
+
+// handleSignals shuts down cleanly on SIGINT/SIGTERM and reloads the config on SIGHUP.
+// Windows only delivers os.Interrupt; registering the others there is harmless.
+func handleSignals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		for sig := range signals {
+			if sig == syscall.SIGHUP {
+				reloadConfig()
+				continue
+			}
+			go shutdown(fmt.Sprintf("Received %v", sig))
+			// A second signal while shutting down (e.g. a stuck restore) exits immediately
+			for sig := range signals {
+				if sig != syscall.SIGHUP {
+					appState.AddLog(fmt.Sprintf("Received %v again, exiting without cleanup", sig))
+					saveSessionLog()
+					os.Exit(1)
+				}
+			}
+		}
+	}()
+}
+
+// shutdown stops the service, restores DNS if restore_on_exit is set, writes the
+// logs to disk and exits. It is used by the tray Quit item and the signal handler.
+func shutdown(reason string) {
+	shutdownOnce.Do(func() {
+		appState.AddLog(fmt.Sprintf("%s, shutting down", reason))
+
+		appState.SetRunning(false)
+		ticker := appState.GetTicker()
+		if ticker != nil {
+			ticker.Stop()
+			appState.SetTicker(nil)
+		}
+
+		if config.RestoreOnExit {
+			if err := restoreDNS(); err != nil {
+				appState.AddLog(fmt.Sprintf("WARNING: Failed to restore DNS on exit: %v", err))
+			}
+		} else if servers, _ := appState.GetCurrentDNS(); len(servers) > 0 {
+			// The journal stays, so the next run knows these settings are ours
+			stopDriftWatcher()
+			cancelDNSVerification()
+			if err := markDNSJournalLeftApplied(); err != nil {
+				appState.AddLog(fmt.Sprintf("Warning: Failed to update DNS journal: %v", err))
+			}
+			appState.AddLog(fmt.Sprintf("Leaving %s applied (restore_on_exit is off)", strings.Join(servers, ", ")))
+		}
+
+		if appState.GetDebugMode() {
+			appState.AddLog("Exiting the application")
+		}
+		saveSessionLog()
+		if guiApp != nil {
+			guiApp.Quit()
+		}
+		os.Exit(0)
+	})
+}
+
+// saveSessionLog writes the logs of this session next to the config
+func saveSessionLog() {
+	path, err := getDataPath(sessionLogFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to save logs: %v\n", err)
+		return
+	}
+	logs := appState.GetLogs()
+	data := []byte(strings.Join(logs, "\n") + "\n")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to save logs: %v\n", err)
+	}
+	os.Stdout.Sync()
+}
+
+// reloadConfig re-reads config.yaml, e.g. after it was edited by hand
+func reloadConfig() {
+	previousBackend := config.LinuxBackend
+	if err := readConfig(); err != nil {
+		appState.AddLog(fmt.Sprintf("ERROR: Failed to reload config: %v", err))
+		updateLogsDisplay()
+		return
+	}
+	appState.AddLog(fmt.Sprintf("Reloaded config at %s", time.Now().Format(time.TimeOnly)))
+
+	if dryRun := forceDryRun || config.DryRun; dryRun != appState.GetDryRun() {
+		if dryRunLocked() {
+			appState.AddLog("dry_run changed, stop the service and restore DNS, then reload again to switch it")
+		} else {
+			appState.SetDryRun(dryRun)
+		}
+	}
+
+	if config.LinuxBackend != previousBackend {
+		if servers, _ := appState.GetCurrentDNS(); len(servers) > 0 {
+			// Switching now would leave the current settings without a backend to restore them
+			appState.AddLog("linux_backend changed, restart AlternateDNS to switch backends")
+		} else if runtime.GOOS == "linux" {
+			selectLinuxBackend()
+		}
+	}
+
+	if appState.IsRunning() {
+		restartTicker()
+	}
+	refreshSettingsTab()
+	updateLogsDisplay()
+	updateStatusDisplay()
+}
@@ -9,13 +9,16 @@ import (
 type AppState struct {
 	mu                sync.RWMutex
 	isRunning         bool
-	currentDNS        string
+	currentDNS        []string // applied nameservers, primary first
 	currentDNSIndex   int
 	nextChangeTime    time.Time
 	debugMode         bool
+	dryRun            bool
 	ticker            *time.Ticker
 	interfaces        []string
 	selectedInterface string
+	dnsManager        string
+	systemDNS         []interfaceDNS // resolvers the system is using, as last read back
 	logs              []string
 	maxLogs           int
 }
@@ -36,17 +39,20 @@ func (s *AppState) IsRunning() bool {
 	return s.isRunning
 }
 
-func (s *AppState) SetCurrentDNS(dns string, index int) {
+// SetCurrentDNS records the applied nameservers and the config index of the primary one
+func (s *AppState) SetCurrentDNS(servers []string, index int) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.currentDNS = dns
+	s.currentDNS = append([]string(nil), servers...)
 	s.currentDNSIndex = index
 }
 
-func (s *AppState) GetCurrentDNS() (string, int) {
+func (s *AppState) GetCurrentDNS() ([]string, int) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	return s.currentDNS, s.currentDNSIndex
+	servers := make([]string, len(s.currentDNS))
+	copy(servers, s.currentDNS)
+	return servers, s.currentDNSIndex
 }
 
 func (s *AppState) SetNextChangeTime(t time.Time) {
@@ -73,6 +79,19 @@ func (s *AppState) GetDebugMode() bool {
 	return s.debugMode
 }
 
+// SetDryRun switches dry-run mode, in which system changes are described instead of performed
+func (s *AppState) SetDryRun(dryRun bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dryRun = dryRun
+}
+
+func (s *AppState) GetDryRun() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.dryRun
+}
+
 func (s *AppState) SetTicker(t *time.Ticker) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -128,6 +147,34 @@ func (s *AppState) GetSelectedInterface() string {
 	return s.selectedInterface
 }
 
+// SetDNSManager records a description of what manages DNS on the system
+func (s *AppState) SetDNSManager(manager string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dnsManager = manager
+}
+
+func (s *AppState) GetDNSManager() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.dnsManager
+}
+
+// SetSystemDNS records the resolver configuration read back from the system
+func (s *AppState) SetSystemDNS(configs []interfaceDNS) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.systemDNS = append([]interfaceDNS(nil), configs...)
+}
+
+func (s *AppState) GetSystemDNS() []interfaceDNS {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	configs := make([]interfaceDNS, len(s.systemDNS))
+	copy(configs, s.systemDNS)
+	return configs
+}
+
 func (s *AppState) AddLog(message string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// Every operation that changes the system goes through the helpers in this file,
+// so that dry-run mode can describe it instead of performing it. Read-only
+// queries (nmcli ... show, D-Bus property reads) run normally in dry-run mode.
+
+// dryRunLog reports what would have been done in the Logs tab
+func dryRunLog(message string) {
+	log.Printf("[dry-run] %s", message)
+}
+
+// runSystemCommand runs a command that changes system settings and returns its combined output.
+// stdin may be empty.
+func runSystemCommand(stdin string, name string, args ...string) ([]byte, error) {
+	if appState.GetDryRun() {
+		description := "would run: " + formatCommand(name, args)
+		if stdin != "" {
+			description += fmt.Sprintf("\nwith input:\n%s", stdin)
+		}
+		dryRunLog(description)
+		return nil, nil
+	}
+	cmd := exec.Command(name, args...)
+	if stdin != "" {
+		cmd.Stdin = strings.NewReader(stdin)
+	}
+	return cmd.CombinedOutput()
+}
+
+// callSystemDBus invokes a D-Bus method that changes system settings
+func callSystemDBus(dest, path, method string, args ...interface{}) error {
+	if appState.GetDryRun() {
+		formatted := make([]string, len(args))
+		for i, arg := range args {
+			formatted[i] = fmt.Sprintf("%+v", arg)
+		}
+		dryRunLog(fmt.Sprintf("would call D-Bus %s on %s %s(%s)", method, dest, path, strings.Join(formatted, ", ")))
+		return nil
+	}
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		return fmt.Errorf("failed to connect to system bus: %v", err)
+	}
+	return conn.Object(dest, dbus.ObjectPath(path)).Call(method, 0, args...).Err
+}
+
+// writeSystemFile atomically replaces a file; in dry-run mode it logs a unified diff instead
+func writeSystemFile(path string, data []byte, perm os.FileMode, uid, gid int) error {
+	if appState.GetDryRun() {
+		old, err := os.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		diff := unifiedDiff(path, old, data)
+		if diff == "" {
+			dryRunLog(fmt.Sprintf("would rewrite %s (mode %v) without changes", path, perm))
+		} else {
+			dryRunLog(fmt.Sprintf("would write %s (mode %v):\n%s", path, perm, diff))
+		}
+		return nil
+	}
+	return writeFileAtomic(path, data, perm, uid, gid)
+}
+
+// removeSystemFile removes a file
+func removeSystemFile(path string) error {
+	if appState.GetDryRun() {
+		dryRunLog(fmt.Sprintf("would remove %s", path))
+		return nil
+	}
+	return os.Remove(path)
+}
+
+// symlinkSystemFile atomically points path at target, replacing whatever is there
+func symlinkSystemFile(target, path string) error {
+	if appState.GetDryRun() {
+		dryRunLog(fmt.Sprintf("would replace %s with a symlink to %s", path, target))
+		return nil
+	}
+	// Create the link under a temporary name first so the path is never missing
+	tmpPath := path + ".alternatedns-tmp"
+	_ = os.Remove(tmpPath)
+	if err := os.Symlink(target, tmpPath); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// mkdirSystem creates a directory and its parents
+func mkdirSystem(path string, perm os.FileMode) error {
+	if appState.GetDryRun() {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			dryRunLog(fmt.Sprintf("would create directory %s", path))
+		}
+		return nil
+	}
+	return os.MkdirAll(path, perm)
+}
+
+// formatCommand renders a command line, quoting arguments that need it
+func formatCommand(name string, args []string) string {
+	parts := []string{name}
+	for _, arg := range args {
+		if arg == "" || strings.ContainsAny(arg, " \t\n'\"\\$|&;<>()*?") {
+			parts = append(parts, "'"+strings.ReplaceAll(arg, "'", `'\''`)+"'")
+		} else {
+			parts = append(parts, arg)
+		}
+	}
+	return strings.Join(parts, " ")
+}